@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminHandler wraps a handler of the admin API, requiring POST requests
+// authenticated by the configured token as a bearer token. The admin API is
+// disabled if no token is configured.
+func adminHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.Admin.Token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(conf.Admin.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fenster"`)
+			apiError(w, "Missing or invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			apiError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// purgeCaches removes the cached query results, labels and stale responses
+// matching the resource URI, or the URI prefix, or all of them. It returns the
+// number of entries removed.
+func purgeCaches(uri, prefix string, all bool) int {
+	var matchQuery, matchIRI func(string) bool
+	switch {
+	case all:
+		matchQuery = func(string) bool { return true }
+		matchIRI = matchQuery
+	case uri != "":
+		matchQuery = func(key string) bool { return strings.Contains(key, "<"+uri+">") }
+		matchIRI = func(iri string) bool { return iri == uri }
+	default:
+		matchQuery = func(key string) bool { return strings.Contains(key, "<"+prefix) }
+		matchIRI = func(iri string) bool { return strings.HasPrefix(iri, prefix) }
+	}
+
+	n := labelCache.RemoveIf(matchIRI)
+	if repo.cache != nil {
+		n += repo.cache.results.RemoveIf(matchQuery)
+	}
+	if repo.stale != nil {
+		n += repo.stale.RemoveIf(matchQuery)
+	}
+	return n
+}
+
+// purgeHandler purges the caches of the resource given by the uri parameter,
+// of the resources given by the prefix parameter, or entirely if the all
+// parameter is true.
+func purgeHandler(w http.ResponseWriter, r *http.Request) {
+	uri, prefix, all := r.FormValue("uri"), r.FormValue("prefix"), r.FormValue("all") == "true"
+	switch {
+	case all:
+	case uri != "" && validIRI(uri):
+	case prefix != "" && validIRI(prefix):
+	default:
+		apiError(w, "Missing or invalid uri, prefix or all parameter", http.StatusBadRequest)
+		return
+	}
+
+	n := purgeCaches(uri, prefix, all)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Purged int `json:"purged"`
+	}{n})
+}
+
+// warmStatus is the progress of a warm-up of the caches, as exported by
+// statusHandler.
+type warmStatus struct {
+	Running  bool
+	Started  time.Time
+	Finished time.Time
+	Total    int
+	Done     int
+	Failed   int
+	// Errors are the last errors, at most maxWarmErrors.
+	Errors []string
+}
+
+const maxWarmErrors = 10
+
+// warmer runs one warm-up of the caches at a time.
+type warmer struct {
+	mu     sync.Mutex
+	status warmStatus
+}
+
+var warmup warmer
+
+// Status returns a snapshot of the progress of the latest warm-up, or nil if
+// none has been run.
+func (wm *warmer) Status() *warmStatus {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if wm.status.Started.IsZero() {
+		return nil
+	}
+	s := wm.status
+	s.Errors = append([]string(nil), s.Errors...)
+	return &s
+}
+
+// Start warms the caches with the descriptions of the URIs in the background,
+// describing at most concurrency resources at a time. It returns false if a
+// warm-up is already running.
+func (wm *warmer) Start(uris []string, concurrency int) bool {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if wm.status.Running {
+		return false
+	}
+	wm.status = warmStatus{Running: true, Started: time.Now(), Total: len(uris)}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	go func() {
+		queue := make(chan string)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for uri := range queue {
+					wm.done(uri, warmResource(uri))
+				}
+			}()
+		}
+		for _, uri := range uris {
+			queue <- uri
+		}
+		close(queue)
+		wg.Wait()
+
+		wm.mu.Lock()
+		wm.status.Running = false
+		wm.status.Finished = time.Now()
+		wm.mu.Unlock()
+	}()
+	return true
+}
+
+// done records the outcome of warming the cache of one resource.
+func (wm *warmer) done(uri string, err error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.status.Done++
+	if err != nil {
+		wm.status.Failed++
+		wm.status.Errors = append(wm.status.Errors, uri+": "+err.Error())
+		if len(wm.status.Errors) > maxWarmErrors {
+			wm.status.Errors = wm.status.Errors[1:]
+		}
+	}
+}
+
+// warmResource runs the queries of the first page of the resource, so their
+// results are cached.
+func warmResource(uri string) error {
+	ctx := context.Background()
+	d, err := describe(ctx, uri, defaultWindow(), restriction{})
+	if err != nil {
+		return err
+	}
+	if d.partial() {
+		countSolutions(ctx, uri, d.restriction)
+	}
+	resolveLabels(ctx, pageIRIs(d), conf.UI.Languages)
+	return nil
+}
+
+// readURIs returns the valid IRIs in a list with one IRI per line. Empty
+// lines, and lines starting with #, are skipped.
+func readURIs(r io.Reader) ([]string, error) {
+	var uris []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.Trim(line, "<>")
+		if validIRI(line) {
+			uris = append(uris, line)
+		}
+	}
+	return uris, scanner.Err()
+}
+
+// warmHandler starts warming the caches with the URIs given in the request
+// body, one per line, or in the list file given by the file parameter.
+func warmHandler(w http.ResponseWriter, r *http.Request) {
+	var list io.Reader = r.Body
+	if file := r.URL.Query().Get("file"); file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			apiError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		list = f
+	}
+	uris, err := readURIs(list)
+	if err != nil {
+		apiError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(uris) == 0 {
+		apiError(w, "No valid URIs to warm", http.StatusBadRequest)
+		return
+	}
+
+	if !warmup.Start(uris, conf.Admin.WarmConcurrency) {
+		apiError(w, "A warm-up is already running; see /.status", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		Warming int `json:"warming"`
+	}{len(uris)})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler(t *testing.T) {
+	h := adminHandler(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		token, method, auth string
+		want                int
+	}{
+		{"", "POST", "Bearer secret", http.StatusNotFound},
+		{"secret", "POST", "", http.StatusUnauthorized},
+		{"secret", "POST", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "GET", "Bearer secret", http.StatusMethodNotAllowed},
+		{"secret", "POST", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		conf.Admin.Token = tt.token
+		r := httptest.NewRequest(tt.method, "/.admin/purge", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.want {
+			t.Errorf("token %q, %s with %q => %d; want %d", tt.token, tt.method, tt.auth, w.Code, tt.want)
+		}
+	}
+	conf.Admin.Token = ""
+}
+
+func TestPurgeCaches(t *testing.T) {
+	labelCache = newLRUCache(10)
+	dir, err := ioutil.TempDir("", "fenster-stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stale, err := newStaleStore(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo = &remoteRepo{cache: newQueryCache(10, 60, nil), stale: stale}
+	for _, iri := range []string{"http://data.deichman.no/a", "http://data.deichman.no/ab", "http://example.org/c"} {
+		q := "SELECT ?p ?o WHERE { <" + iri + "> ?p ?o }"
+		labelCache.Set(iri, []labelCandidate{}, time.Minute)
+		repo.cache.results.Set(q, []byte{}, time.Minute)
+		repo.stale.Set("json\n"+q, []byte{})
+	}
+
+	if n := purgeCaches("http://data.deichman.no/a", "", false); n != 3 {
+		t.Errorf("purging by uri removed %d entries; want 3", n)
+	}
+	if n := purgeCaches("", "http://data.deichman.no/", false); n != 3 {
+		t.Errorf("purging by prefix removed %d entries; want 3", n)
+	}
+	if n := purgeCaches("", "", true); n != 3 {
+		t.Errorf("purging all removed %d entries; want 3", n)
+	}
+}
+
+func TestReadURIs(t *testing.T) {
+	list := "# resources\nhttp://data.deichman.no/a\n\n<http://data.deichman.no/b>\nnot an iri\n"
+	want := []string{"http://data.deichman.no/a", "http://data.deichman.no/b"}
+	if got, err := readURIs(strings.NewReader(list)); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("readURIs => %v, %v; want %v", got, err, want)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// apiResource is the JSON representation of a resource description, as served
+// by the versioned JSON API.
+type apiResource struct {
+	URI        string        `json:"uri"`
+	Title      string        `json:"title,omitempty"`
+	Images     []string      `json:"images,omitempty"`
+	Properties []apiProperty `json:"properties"`
+	Incoming   []apiIncoming `json:"incoming"`
+	Counts     apiCounts     `json:"counts"`
+	Links      apiLinks      `json:"links"`
+	// BlankNodes are the properties of the blank nodes among the values,
+	// keyed by blank node label.
+	BlankNodes map[string][]apiProperty `json:"blankNodes,omitempty"`
+	// CachedAt is set when the SPARQL endpoint failed, and the description
+	// is the last good one, stored at the given time.
+	CachedAt string `json:"cachedAt,omitempty"`
+	// Truncated is set when a response from the SPARQL endpoint exceeded
+	// the size limit, and the description holds the triples read until then.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// apiProperty groups the values of an outgoing predicate.
+type apiProperty struct {
+	Predicate string     `json:"predicate"`
+	Values    []apiValue `json:"values"`
+}
+
+// apiIncoming groups the subjects linking to the resource by predicate.
+type apiIncoming struct {
+	Predicate string     `json:"predicate"`
+	Subjects  []apiValue `json:"subjects"`
+}
+
+// apiValue is an RDF term and the graph it was found in. Literals with a
+// numeric or boolean datatype have their value typed accordingly.
+type apiValue struct {
+	Type     string      `json:"type"`
+	Value    interface{} `json:"value"`
+	Lang     string      `json:"lang,omitempty"`
+	Datatype string      `json:"datatype,omitempty"`
+	Graph    string      `json:"graph"`
+}
+
+type apiCounts struct {
+	Outgoing int `json:"outgoing"`
+	Incoming int `json:"incoming"`
+}
+
+type apiLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// newAPIValue converts an RDF term into an apiValue.
+func newAPIValue(t rdf.Term, graph rdf.Term) apiValue {
+	v := apiValue{Value: t.String()}
+	if graph != nil {
+		v.Graph = graph.String()
+	}
+	switch t.Type() {
+	case rdf.TermIRI:
+		v.Type = "uri"
+	case rdf.TermBlank:
+		v.Type = "bnode"
+	case rdf.TermLiteral:
+		v.Type = "literal"
+		l := t.(rdf.Literal)
+		v.Lang = l.Lang()
+		if v.Lang == "" && l.DataType.String() != nsXSD+"string" {
+			v.Datatype = l.DataType.String()
+			if typed, err := l.Typed(); err == nil {
+				switch typed.(type) {
+				case int, int64, float64, bool:
+					v.Value = typed
+				}
+			}
+		}
+	}
+	return v
+}
+
+// apiProperties groups the objects of solutions binding ?g ?p ?o by predicate.
+func apiProperties(solutions []map[string]rdf.Term) []apiProperty {
+	var props []apiProperty
+	idx := make(map[string]int)
+	for _, m := range solutions {
+		p := m["p"].String()
+		i, ok := idx[p]
+		if !ok {
+			i = len(props)
+			idx[p] = i
+			props = append(props, apiProperty{Predicate: p})
+		}
+		props[i].Values = append(props[i].Values, newAPIValue(m["o"], m["g"]))
+	}
+	return props
+}
+
+// newAPIResource builds the JSON representation of a resource description,
+// with the title in the most preferred of the languages.
+func newAPIResource(d *description, langs []string) *apiResource {
+	res := apiResource{
+		URI:        d.URI,
+		Title:      findTitle(conf.UI.TitlePredicates, langs, d.Outgoing),
+		Images:     findImages(conf.UI.ImagePredicates, d.Outgoing),
+		Properties: []apiProperty{},
+		Incoming:   []apiIncoming{},
+		Counts:     apiCounts{len(d.Outgoing), len(d.Incoming)},
+	}
+	res.Properties = append(res.Properties, apiProperties(d.Outgoing)...)
+	for b, solutions := range d.BlankNodes {
+		if res.BlankNodes == nil {
+			res.BlankNodes = make(map[string][]apiProperty)
+		}
+		res.BlankNodes[b] = apiProperties(solutions)
+	}
+	incoming := make(map[string]int)
+	for _, m := range d.Incoming {
+		p := m["p"].String()
+		i, ok := incoming[p]
+		if !ok {
+			i = len(res.Incoming)
+			incoming[p] = i
+			res.Incoming = append(res.Incoming, apiIncoming{Predicate: p})
+		}
+		res.Incoming[i].Subjects = append(res.Incoming[i].Subjects, newAPIValue(m["s"], m["g"]))
+	}
+	return &res
+}
+
+// apiError serves an error message as a JSON document.
+func apiError(w http.ResponseWriter, msg string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+// serveAPIResource serves the JSON representation of the resource.
+func serveAPIResource(w http.ResponseWriter, r *http.Request, uri string) {
+	win := requestWindow(r)
+	d, err := describe(r.Context(), uri, win, requestRestriction(r))
+	if err != nil {
+		log.Printf("%s: %v", r.URL.Path, err)
+		apiError(w, upstreamErrorMessage(err), upstreamErrorStatus(w, err))
+		return
+	}
+	if len(d.Outgoing) == 0 && len(d.Incoming) == 0 && win.first() {
+		apiError(w, "This URI has no information", http.StatusNotFound)
+		return
+	}
+
+	doc := newAPIResource(d, requestLanguages(r))
+	if d.partial() {
+		// Fetch solution counts, if the page is not the complete description
+		if maxS, maxO, err := countSolutions(r.Context(), uri, d.restriction); err == nil {
+			doc.Counts.Outgoing, doc.Counts.Incoming = maxS, maxO
+		}
+	}
+
+	if !d.CachedAt.IsZero() {
+		doc.CachedAt = d.CachedAt.UTC().Format(time.RFC3339)
+	}
+	doc.Truncated = d.Truncated
+	doc.Links.Self = win.url(r)
+	if d.hasNext() {
+		doc.Links.Next = win.next().url(r)
+	}
+	if !win.first() {
+		doc.Links.Prev = win.prev().url(r)
+	}
+
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+	if err := json.NewEncoder(buf).Encode(doc); err != nil {
+		log.Printf("%s: %v", r.URL.Path, err)
+		apiError(w, "The resource could not be encoded as JSON.", http.StatusInternalServerError)
+		return
+	}
+
+	setLinkHeaders(w, r, win, d.hasNext())
+	w.Header().Add("Vary", "Accept-Language")
+	maxAge := setStaleHeaders(w, d.CachedAt, conf.Cache.DataMaxAge)
+	if checkConditional(w, r, bodyETag(buf.Bytes()), lastModified(d.Outgoing), maxAge) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	buf.WriteTo(w)
+}
+
+// apiResourceHandler serves the JSON representation of the resource given by
+// the uri parameter.
+func apiResourceHandler(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("uri")
+	if !validIRI(uri) {
+		apiError(w, "Missing or invalid uri parameter", http.StatusBadRequest)
+		return
+	}
+	serveAPIResource(w, r, uri)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeAPIResourceError(t *testing.T) {
+	bank, r := qBank, repo
+	t.Cleanup(func() { qBank, repo = bank, r })
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	endpoint := ts.URL
+	ts.Close()
+	qBank = lookupDialect("").bank(queries)
+	repo = newRepo(newEndpointPool([]string{endpoint}, 5, time.Second),
+		clientOptions{OpenTimeout: time.Second, ReadTimeout: time.Second})
+
+	w := httptest.NewRecorder()
+	serveAPIResource(w, httptest.NewRequest("GET", "/api/resource?uri=http://example.org/r", nil), "http://example.org/r")
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when the endpoint is down, got %d", w.Code)
+	}
+	if body := w.Body.String(); strings.Contains(body, strings.TrimPrefix(endpoint, "http://")) {
+		t.Errorf("expected the endpoint not to be named in the response, got %s", body)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Authentication schemes of the SPARQL endpoints.
+const (
+	authBasic  = "basic"
+	authDigest = "digest"
+	authBearer = "bearer"
+)
+
+// readSecret returns the value of a configured secret. A value starting with
+// "env:" is read from the named environment variable, and a value starting
+// with "file:" from the named file; other values are used as they are.
+func readSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, "file:"):
+		b, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return value, nil
+}
+
+// credentials authenticate the requests to the SPARQL endpoints.
+type credentials struct {
+	Scheme   string
+	Username string
+	Password string
+	Token    string
+}
+
+// newCredentials returns the credentials of the authentication scheme, with
+// the secrets read by readSecret; or nil if no scheme is given.
+func newCredentials(scheme, username, password, token string) (*credentials, error) {
+	c := &credentials{Scheme: strings.ToLower(scheme)}
+	var err error
+	switch c.Scheme {
+	case "":
+		return nil, nil
+	case authBasic, authDigest:
+		if c.Username, err = readSecret(username); err != nil {
+			return nil, err
+		}
+		c.Password, err = readSecret(password)
+	case authBearer:
+		c.Token, err = readSecret(token)
+	default:
+		return nil, fmt.Errorf("unknown authentication scheme: %q", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newTLSConfig returns the TLS configuration presenting the client
+// certificate, if given, and trusting the certificate authorities in the CA
+// bundle besides the system ones, if given; or nil if neither is.
+func newTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && caFile == "" {
+		return nil, nil
+	}
+	c := &tls.Config{}
+	if certFile != "" {
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", caFile)
+		}
+		c.RootCAs = pool
+	}
+	return c, nil
+}
+
+// authTransport adds the credentials to the requests it sends.
+type authTransport struct {
+	base  http.RoundTripper
+	creds *credentials
+
+	// The digest challenge of the endpoints, by host
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge
+}
+
+func newAuthTransport(base http.RoundTripper, creds *credentials) *authTransport {
+	return &authTransport{base: base, creds: creds, challenges: make(map[string]*digestChallenge)}
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch t.creds.Scheme {
+	case authBasic:
+		req = req.Clone(req.Context())
+		req.SetBasicAuth(t.creds.Username, t.creds.Password)
+	case authBearer:
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+t.creds.Token)
+	case authDigest:
+		return t.digest(req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// digest sends the request with Digest authentication, answering the last
+// challenge of the endpoint. If there is none, or it is no longer valid, the
+// request is sent again answering the new challenge.
+func (t *authTransport) digest(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	ch := t.challenges[req.URL.Host]
+	t.mu.Unlock()
+
+	first := req
+	if ch != nil {
+		first = req.Clone(req.Context())
+		first.Header.Set("Authorization", ch.authorize(req, t.creds))
+	}
+	resp, err := t.base.RoundTrip(first)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	ch, ok := parseDigestChallenge(resp.Header.Get("WWW-Authenticate"))
+	if !ok {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	t.mu.Lock()
+	t.challenges[req.URL.Host] = ch
+	t.mu.Unlock()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", ch.authorize(req, t.creds))
+	return t.base.RoundTrip(retry)
+}
+
+// digestChallenge is the WWW-Authenticate challenge of Digest authentication.
+type digestChallenge struct {
+	Realm, Nonce, Opaque, Algorithm string
+	// QOP is true if the server supports the auth quality of protection
+	QOP bool
+
+	mu sync.Mutex
+	nc int
+}
+
+// parseDigestChallenge parses a WWW-Authenticate header of Digest
+// authentication.
+func parseDigestChallenge(header string) (*digestChallenge, bool) {
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Digest ") {
+		return nil, false
+	}
+	params := make(map[string]string)
+	s := strings.TrimSpace(header[7:])
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, false
+			}
+			value = strings.Replace(s[1:end], `\`, "", -1)
+			s = s[end+1:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = value
+		s = strings.TrimLeft(s, ", ")
+	}
+
+	ch := &digestChallenge{
+		Realm:     params["realm"],
+		Nonce:     params["nonce"],
+		Opaque:    params["opaque"],
+		Algorithm: params["algorithm"],
+	}
+	for _, q := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			ch.QOP = true
+		}
+	}
+	return ch, ch.Nonce != ""
+}
+
+// authorize returns the Authorization header answering the challenge for the
+// request.
+func (ch *digestChallenge) authorize(req *http.Request, c *credentials) string {
+	algorithm := strings.ToUpper(ch.Algorithm)
+	var h func() hash.Hash
+	switch strings.TrimSuffix(algorithm, "-SESS") {
+	case "SHA-256":
+		h = sha256.New
+	default:
+		h = md5.New
+	}
+	digest := func(parts ...string) string {
+		d := h()
+		d.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	ch.mu.Lock()
+	ch.nc++
+	nc := fmt.Sprintf("%08x", ch.nc)
+	ch.mu.Unlock()
+	cnonce := newCnonce()
+
+	uri := req.URL.RequestURI()
+	ha1 := digest(c.Username, ch.Realm, c.Password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = digest(ha1, ch.Nonce, cnonce)
+	}
+	ha2 := digest(req.Method, uri)
+
+	var response string
+	if ch.QOP {
+		response = digest(ha1, ch.Nonce, nc, cnonce, "auth", ha2)
+	} else {
+		response = digest(ha1, ch.Nonce, ha2)
+	}
+
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, c.Username),
+		fmt.Sprintf(`realm="%s"`, ch.Realm),
+		fmt.Sprintf(`nonce="%s"`, ch.Nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if ch.Algorithm != "" {
+		fields = append(fields, "algorithm="+ch.Algorithm)
+	}
+	if ch.Opaque != "" {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, ch.Opaque))
+	}
+	if ch.QOP {
+		fields = append(fields, "qop=auth", "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+	return "Digest " + strings.Join(fields, ", ")
+}
+
+// newCnonce returns a random client nonce.
+func newCnonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSecret(t *testing.T) {
+	os.Setenv("FENSTER_TEST_SECRET", "from env")
+	defer os.Unsetenv("FENSTER_TEST_SECRET")
+	dir, err := ioutil.TempDir("", "fenster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "secret")
+	ioutil.WriteFile(file, []byte("from file\n"), 0600)
+
+	tests := map[string]string{
+		"env:FENSTER_TEST_SECRET": "from env",
+		"file:" + file:            "from file",
+		"plain":                   "plain",
+	}
+	for value, want := range tests {
+		if got, err := readSecret(value); err != nil || got != want {
+			t.Errorf("readSecret(%q) => %q, %v; want %q", value, got, err, want)
+		}
+	}
+	if _, err := readSecret("env:FENSTER_TEST_UNSET"); err == nil {
+		t.Errorf("expected unset environment variable to fail")
+	}
+	if _, err := newCredentials("ntlm", "", "", ""); err == nil {
+		t.Errorf("expected unknown scheme to fail")
+	}
+}
+
+func TestAuthTransport(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		creds credentials
+		want  string
+	}{
+		{credentials{Scheme: authBasic, Username: "user", Password: "pass"}, "Basic dXNlcjpwYXNz"},
+		{credentials{Scheme: authBearer, Token: "secret"}, "Bearer secret"},
+	}
+	for _, tt := range tests {
+		client := &http.Client{Transport: newAuthTransport(http.DefaultTransport, &tt.creds)}
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got != tt.want {
+			t.Errorf("%s: Authorization => %q; want %q", tt.creds.Scheme, got, tt.want)
+		}
+	}
+}
+
+// digestParams returns the parameters of a Digest Authorization header.
+func digestParams(header string) map[string]string {
+	params := make(map[string]string)
+	for _, f := range strings.Split(strings.TrimPrefix(header, "Digest "), ", ") {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return params
+}
+
+func TestDigestAuth(t *testing.T) {
+	md5hex := func(s string) string {
+		h := md5.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	challenges := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := digestParams(r.Header.Get("Authorization"))
+		ha1 := md5hex("user:fenster:pass")
+		ha2 := md5hex(r.Method + ":" + p["uri"])
+		want := md5hex(strings.Join([]string{ha1, "n1", p["nc"], p["cnonce"], "auth", ha2}, ":"))
+		if p["response"] != want || p["uri"] != r.URL.RequestURI() {
+			challenges++
+			w.Header().Set("WWW-Authenticate", `Digest realm="fenster", nonce="n1", qop="auth,auth-int", opaque="o"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", p["nc"], body)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: newAuthTransport(http.DefaultTransport,
+		&credentials{Scheme: authDigest, Username: "user", Password: "pass"})}
+	for _, want := range []string{"00000001 query=1", "00000002 query=2"} {
+		resp, err := client.Post(ts.URL+"/sparql-auth?x=y", "application/x-www-form-urlencoded",
+			strings.NewReader("query="+want[len(want)-1:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(b) != want {
+			t.Errorf("expected authenticated request with its body, got %d %q", resp.StatusCode, b)
+		}
+	}
+	if challenges != 1 {
+		t.Errorf("expected the challenge to be reused, got %d challenges", challenges)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	if c, err := newTLSConfig("", "", ""); c != nil || err != nil {
+		t.Errorf("expected default TLS configuration, got %v, %v", c, err)
+	}
+	if _, err := newTLSConfig("", "", "/nonexistent/ca.pem"); err == nil {
+		t.Errorf("expected missing CA bundle to fail")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// genidPath is the path of the pages of skolemized blank nodes.
+const genidPath = "/.well-known/genid/"
+
+// blankStep is a step from one blank node to the next in the chain of a
+// blank node expansion query; binding ?b<From> ?p<To> ?b<To>.
+type blankStep struct {
+	From, To int
+}
+
+// blankLevels returns the chains of steps from the blank node objects of a
+// resource to the nested blank nodes, one chain per level of nesting, up to
+// the given depth.
+func blankLevels(depth int) [][]blankStep {
+	var levels [][]blankStep
+	for i := 0; i < depth; i++ {
+		var steps []blankStep
+		for j := 1; j <= i; j++ {
+			steps = append(steps, blankStep{j - 1, j})
+		}
+		levels = append(levels, steps)
+	}
+	return levels
+}
+
+// splitBlankNodes separates the solutions of the outgoing query describing the
+// resource itself from those describing its blank nodes, which bind ?b to the
+// blank node. The latter are returned keyed by blank node label.
+func splitBlankNodes(solutions []map[string]rdf.Term) ([]map[string]rdf.Term, map[string][]map[string]rdf.Term) {
+	var own []map[string]rdf.Term
+	blanks := make(map[string][]map[string]rdf.Term)
+	for _, m := range solutions {
+		if b := m["b"]; b != nil {
+			blanks[b.String()] = append(blanks[b.String()], m)
+			continue
+		}
+		own = append(own, m)
+	}
+	return own, blanks
+}
+
+// limitQuads returns the first limit quads with the resource as subject,
+// together with the quads describing the blank nodes reachable from them,
+// and reports if there were more.
+func limitQuads(uri string, quads []rdf.Quad, limit int) ([]rdf.Quad, bool) {
+	var own, nested []rdf.Quad
+	for _, q := range quads {
+		if q.Subj.Type() == rdf.TermIRI && q.Subj.String() == uri {
+			own = append(own, q)
+		} else {
+			nested = append(nested, q)
+		}
+	}
+	if len(own) <= limit {
+		return quads, false
+	}
+	own = own[:limit]
+
+	reachable := make(map[string]bool)
+	for _, q := range own {
+		if q.Obj.Type() == rdf.TermBlank {
+			reachable[q.Obj.String()] = true
+		}
+	}
+	for found := true; found; {
+		found = false
+		for _, q := range nested {
+			if reachable[q.Subj.String()] && q.Obj.Type() == rdf.TermBlank && !reachable[q.Obj.String()] {
+				reachable[q.Obj.String()] = true
+				found = true
+			}
+		}
+	}
+	for _, q := range nested {
+		if q.Subj.Type() == rdf.TermBlank && reachable[q.Subj.String()] {
+			own = append(own, q)
+		}
+	}
+	return own, true
+}
+
+// checkBlankNodeIRI returns an error unless the blank node IRI template is
+// empty, or has exactly one verb, the %s of the blank node identifier.
+func checkBlankNodeIRI(template string) error {
+	if template == "" {
+		return nil
+	}
+	if strings.Count(template, "%") != 1 || strings.Count(template, "%s") != 1 {
+		return fmt.Errorf("%q must contain %%s once, and no other verb", template)
+	}
+	return nil
+}
+
+// skolemPrefix returns the part of the configured blank node IRI template
+// preceding the blank node identifier.
+func skolemPrefix() string {
+	if i := strings.Index(conf.QuadStore.BlankNodeIRI, "%s"); i != -1 {
+		return conf.QuadStore.BlankNodeIRI[:i]
+	}
+	return conf.QuadStore.BlankNodeIRI
+}
+
+// skolemIRI returns the IRI by which the SPARQL endpoint identifies the blank
+// node with the given identifier.
+func skolemIRI(id string) string {
+	return fmt.Sprintf(conf.QuadStore.BlankNodeIRI, id)
+}
+
+// genidURL returns the URL of the page of a blank node, or an empty string if
+// the endpoint does not identify blank nodes by IRIs.
+func genidURL(t rdf.Term) string {
+	if conf.QuadStore.BlankNodeIRI == "" {
+		return ""
+	}
+	id := strings.TrimPrefix(t.String(), skolemPrefix())
+	u := url.URL{Path: genidPath + id}
+	return u.String()
+}
+
+// genidHandler serves the presentation of a skolemized blank node; as HTML,
+// or as JSON or RDF given the suffix of the format.
+func genidHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, genidPath)
+	suffix := suffixRg.FindString(id)
+	f, isRDF := findRDFFormat(suffix)
+	if suffix == ".html" || suffix == ".json" || isRDF {
+		id = strings.TrimSuffix(id, suffix)
+	}
+	if conf.QuadStore.BlankNodeIRI == "" || id == "" || !validIRI(skolemIRI(id)) {
+		errorHandler(w, r, "This URI has no information", http.StatusNotFound)
+		return
+	}
+	switch {
+	case suffix == ".json":
+		serveAPIResource(w, r, skolemIRI(id))
+	case isRDF:
+		rdfHandler(w, r, skolemIRI(id), f)
+	default:
+		htmlHandler(w, r, skolemIRI(id))
+	}
+}
+
+// blankNodeHTML returns the HTML presenting a blank node; linking to its page
+// if skolemized, followed by a table of its properties if expanded.
+func blankNodeHTML(t rdf.Term, pres *presentation, depth int) string {
+	label := template.HTMLEscapeString(t.Serialize(rdf.Turtle))
+	if u := genidURL(t); u != "" {
+		label = fmt.Sprintf("<a href='%s'>%s</a>", template.HTMLEscapeString(u), label)
+	}
+	props := pres.BlankNodes[t.String()]
+	if len(props) == 0 || depth >= conf.QuadStore.BlankNodeDepth {
+		return label
+	}
+
+	var b bytes.Buffer
+	b.WriteString(label)
+	b.WriteString("<table class=\"bnode\">")
+	for _, m := range props {
+		b.WriteString("<tr><td class=\"td-pred\">")
+		b.WriteString(htmlString(termHTML("p", m["p"], pres, depth+1)))
+		b.WriteString("</td><td class=\"td-obj\">")
+		b.WriteString(htmlString(termHTML("o", m["o"], pres, depth+1)))
+		b.WriteString("</td></tr>")
+	}
+	b.WriteString("</table>")
+	return b.String()
+}
+
+// htmlString returns the HTML of a value presented by termHTML.
+func htmlString(v interface{}) string {
+	if h, ok := v.(template.HTML); ok {
+		return string(h)
+	}
+	return template.HTMLEscapeString(fmt.Sprint(v))
+}
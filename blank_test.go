@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+func TestBlankLevels(t *testing.T) {
+	want := [][]blankStep{nil, {{0, 1}}, {{0, 1}, {1, 2}}}
+	if got := blankLevels(3); !reflect.DeepEqual(got, want) {
+		t.Errorf("blankLevels(3) => %v; want %v", got, want)
+	}
+	if got := blankLevels(0); got != nil {
+		t.Errorf("blankLevels(0) => %v; want nil", got)
+	}
+}
+
+func TestLimitQuads(t *testing.T) {
+	g, _ := rdf.NewIRI("http://data.deichman.no/graph")
+	s, _ := rdf.NewIRI("http://data.deichman.no/resource/tnr_1")
+	p, _ := rdf.NewIRI("http://purl.org/dc/terms/subject")
+	b1, _ := rdf.NewBlank("b1")
+	b2, _ := rdf.NewBlank("b2")
+	b3, _ := rdf.NewBlank("b3")
+	lit, _ := rdf.NewLiteral("x")
+	quad := func(s rdf.Subject, o rdf.Object) rdf.Quad {
+		return rdf.Quad{Triple: rdf.Triple{Subj: s, Pred: p, Obj: o}, Ctx: g}
+	}
+	quads := []rdf.Quad{
+		quad(s, b1),
+		quad(b1, b2),
+		quad(b2, lit),
+		quad(s, b3),
+		quad(b3, lit),
+	}
+
+	got, more := limitQuads(s.String(), quads, 2)
+	if more || !reflect.DeepEqual(got, quads) {
+		t.Errorf("limitQuads within limit => %v, %v; want %v, false", got, more, quads)
+	}
+
+	want := []rdf.Quad{quads[0], quads[1], quads[2]}
+	got, more = limitQuads(s.String(), quads, 1)
+	if !more || !reflect.DeepEqual(got, want) {
+		t.Errorf("limitQuads beyond limit => %v, %v; want %v, true", got, more, want)
+	}
+}
+
+func TestBlankNodesLimit(t *testing.T) {
+	params := resourceQuery{URI: "http://example.org/r", Limit: 11, BlankNodes: blankLevels(2)}
+	for name, d := range dialects {
+		b := d.bank(queries)
+		for _, tag := range []string{"outgoing", "constructOutgoing"} {
+			if _, ok := b[tag]; !ok {
+				continue
+			}
+			// The triples of the resource, and of each level of blank nodes
+			// and the objects they are found among, are limited
+			q, err := b.Prepare(tag, params)
+			if n := strings.Count(q, "LIMIT 11"); err != nil || n != 5 {
+				t.Errorf("%s %s: expected 5 limits, got %d, %v", name, tag, n, err)
+			}
+		}
+	}
+}
+
+func TestSolutionQuads(t *testing.T) {
+	g, _ := rdf.NewIRI("http://data.deichman.no/graph")
+	s, _ := rdf.NewIRI("http://data.deichman.no/resource/tnr_1")
+	p, _ := rdf.NewIRI("http://purl.org/dc/terms/subject")
+	b, _ := rdf.NewBlank("b1")
+	lit, _ := rdf.NewLiteral("x")
+	solutions := []map[string]rdf.Term{
+		{"g": g, "p": p, "o": b},
+		{"g": g, "p": p, "o": lit, "b": b},
+		{"g": g, "s": b, "p": p},
+	}
+	want := []rdf.Quad{
+		{Triple: rdf.Triple{Subj: s, Pred: p, Obj: b}, Ctx: g},
+		{Triple: rdf.Triple{Subj: b, Pred: p, Obj: lit}, Ctx: g},
+		{Triple: rdf.Triple{Subj: b, Pred: p, Obj: s}, Ctx: g},
+	}
+	got, err := solutionQuads(s.String(), solutions)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("solutionQuads => %v, %v; want %v", got, err, want)
+	}
+
+	if _, err := solutionQuads(s.String(), []map[string]rdf.Term{{"g": g, "o": lit}}); err == nil {
+		t.Error("expected error for solution without predicate")
+	}
+}
+
+func TestCheckBlankNodeIRI(t *testing.T) {
+	for _, tmpl := range []string{"", "nodeID://%s", "http://example.org/.well-known/genid/%s"} {
+		if err := checkBlankNodeIRI(tmpl); err != nil {
+			t.Errorf("checkBlankNodeIRI(%q) => %v; want nil", tmpl, err)
+		}
+	}
+	for _, tmpl := range []string{"nodeID://", "nodeID://%s/%s", "nodeID://%d", "nodeID://%s%%"} {
+		if err := checkBlankNodeIRI(tmpl); err == nil {
+			t.Errorf("checkBlankNodeIRI(%q) => nil; want error", tmpl)
+		}
+	}
+}
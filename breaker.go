@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// States of a circuit breaker.
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// circuitOpenError is the error of a query not sent, because the circuits of
+// all the endpoints are open.
+type circuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e circuitOpenError) Error() string {
+	return fmt.Sprintf("SPARQL endpoint unavailable; retry in %v", e.RetryAfter)
+}
+
+// breaker is a circuit breaker. It opens after a number of consecutive
+// failures, rejecting requests until it has cooled down. Then it lets one
+// trial request through, closing again if it succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trips    int
+	onTrip   func()
+}
+
+// newBreaker returns a closed breaker, opening after threshold consecutive
+// failures, for the duration of cooldown.
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, state: circuitClosed}
+}
+
+// allow returns true if a request may be sent. If not, it also returns how
+// long until the breaker lets a trial request through.
+func (b *breaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+			return false, wait
+		}
+		b.state = circuitHalfOpen
+		return true, 0
+	case circuitHalfOpen:
+		// Only the trial request is let through
+		return false, b.cooldown
+	}
+	return true, 0
+}
+
+// success records a successful request, closing the breaker.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = circuitClosed
+	b.failures = 0
+}
+
+// failure records a failed request, opening the breaker if it reached the
+// threshold of consecutive failures, or if the trial request failed.
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+		b.trips++
+		if b.onTrip != nil {
+			b.onTrip()
+		}
+	}
+}
+
+// release returns the breaker to open if a trial request let through ended
+// without a verdict on the endpoint.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpen
+	}
+}
+
+// status returns the state of the breaker, and the number of times it has
+// opened.
+func (b *breaker) status() (string, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	if state == circuitOpen && time.Since(b.openedAt) >= b.cooldown {
+		state = circuitHalfOpen
+	}
+	return state, b.trips
+}
+
+// backoff returns the delay before retry number n, counting from 0; a random
+// duration up to base doubled n times.
+func backoff(base time.Duration, n int) time.Duration {
+	d := base << uint(n)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
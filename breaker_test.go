@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(2, 20*time.Millisecond)
+	trips := 0
+	b.onTrip = func() { trips++ }
+
+	b.failure()
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("expected breaker to stay closed below the threshold")
+	}
+	b.failure()
+	if ok, wait := b.allow(); ok || wait <= 0 {
+		t.Fatalf("expected breaker to open at the threshold, got %v %v", ok, wait)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if ok, _ := b.allow(); !ok {
+		t.Fatal("expected a trial request after the cooldown")
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatal("expected only one trial request while half-open")
+	}
+	b.failure()
+	if state, n := b.status(); state != circuitOpen || n != 2 || trips != 2 {
+		t.Fatalf("expected failed trial to open the breaker again, got %s after %d trips", state, n)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	b.allow()
+	b.success()
+	if state, _ := b.status(); state != circuitClosed {
+		t.Errorf("expected successful trial to close the breaker, got %s", state)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for n := 0; n < 4; n++ {
+		if d := backoff(100*time.Millisecond, n); d < 0 || d >= (100*time.Millisecond)<<uint(n) {
+			t.Errorf("backoff(100ms, %d) = %v; out of range", n, d)
+		}
+	}
+	if d := backoff(0, 3); d != 0 {
+		t.Errorf("expected no backoff with zero base, got %v", d)
+	}
+}
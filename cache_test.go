@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+	c.Get("a")
+	c.Set("c", 3, time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected least recently used entry to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v.(int) != 1 {
+		t.Errorf("expected a => 1, got %v, %v", v, ok)
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+
+	c.Set("d", 4, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := c.Get("d"); ok {
+		t.Errorf("expected expired entry to be missing")
+	}
+
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected removed entry to be missing")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// bodyETag returns a strong entity tag of a response body, rendered from the
+// result set of the queries describing a resource.
+func bodyETag(body []byte) string {
+	h := sha1.Sum(body)
+	return `"` + hex.EncodeToString(h[:]) + `"`
+}
+
+// dataETag returns a weak entity tag of a streamed response, from the data it
+// is rendered from; so the response is not rendered twice. The same data
+// renders an equivalent response by the same version of Fenster.
+func dataETag(data ...interface{}) string {
+	h := sha1.New()
+	fmt.Fprint(h, string(version))
+	for _, d := range data {
+		fmt.Fprintf(h, "\n%v", d)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// parseModified parses the lexical form of an xsd:dateTime or xsd:date.
+func parseModified(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// lastModified returns the latest of the literal objects of the configured
+// modification predicate, among solutions binding ?p ?o; or the zero time if
+// there are none.
+func lastModified(solutions []map[string]rdf.Term) time.Time {
+	var modified time.Time
+	if conf.UI.ModifiedPredicate == "" {
+		return modified
+	}
+	for _, m := range solutions {
+		if m["p"] == nil || m["o"] == nil || m["p"].String() != conf.UI.ModifiedPredicate {
+			continue
+		}
+		if m["o"].Type() != rdf.TermLiteral {
+			continue
+		}
+		if t, ok := parseModified(m["o"].String()); ok && t.After(modified) {
+			modified = t
+		}
+	}
+	return modified
+}
+
+// quadsLastModified returns the latest modification time of the resource
+// stated among the quads, or the zero time if there are none.
+func quadsLastModified(uri string, quads []rdf.Quad) time.Time {
+	var solutions []map[string]rdf.Term
+	for _, q := range quads {
+		if q.Subj.String() == uri {
+			solutions = append(solutions, map[string]rdf.Term{"p": q.Pred, "o": q.Obj})
+		}
+	}
+	return lastModified(solutions)
+}
+
+// cacheControl returns the Cache-Control header value for responses allowed
+// to be cached for maxAge seconds.
+func cacheControl(maxAge int) string {
+	if maxAge <= 0 {
+		return "no-cache"
+	}
+	return fmt.Sprintf("public, max-age=%d", maxAge)
+}
+
+// etagMatch returns true if the entity tag is among the ones in an
+// If-None-Match header; compared weakly, as the header requires.
+func etagMatch(header string, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConditional sets the validator and caching headers of a response, and
+// returns true after responding 304 Not Modified if the request is
+// conditional and the client has the current representation. When both are
+// given, If-None-Match takes precedence over If-Modified-Since.
+func checkConditional(w http.ResponseWriter, r *http.Request, etag string, modified time.Time, maxAge int) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl(maxAge))
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagMatch(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			notModified = !modified.Truncate(time.Second).After(t)
+		}
+	}
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
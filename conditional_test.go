@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckConditional(t *testing.T) {
+	modified := time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+	etag := bodyETag([]byte("body"))
+
+	tests := []struct {
+		header, value string
+		modified      time.Time
+		want          bool
+	}{
+		{"", "", modified, false},
+		{"If-None-Match", etag, modified, true},
+		{"If-None-Match", `"other", ` + etag, modified, true},
+		{"If-None-Match", "W/" + etag, modified, true},
+		{"If-None-Match", "*", modified, true},
+		{"If-None-Match", `"other"`, modified, false},
+		{"If-Modified-Since", "Sun, 01 Jun 2014 12:00:00 GMT", modified, true},
+		{"If-Modified-Since", "Sun, 01 Jun 2014 11:59:59 GMT", modified, false},
+		{"If-Modified-Since", "Sun, 01 Jun 2014 12:00:00 GMT", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/resource/x.html", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		w := httptest.NewRecorder()
+		got := checkConditional(w, r, etag, tt.modified, 300)
+		if got != tt.want {
+			t.Errorf("%s: %s => %v; want %v", tt.header, tt.value, got, tt.want)
+		}
+		if got && w.Code != http.StatusNotModified {
+			t.Errorf("%s: %s => status %d; want 304", tt.header, tt.value, w.Code)
+		}
+		if w.Header().Get("ETag") != etag || w.Header().Get("Cache-Control") != "public, max-age=300" {
+			t.Errorf("missing validator or caching headers: %v", w.Header())
+		}
+	}
+}
+
+func TestParseModified(t *testing.T) {
+	for _, s := range []string{"2014-06-01T12:00:00Z", "2014-06-01T12:00:00", "2014-06-01T14:00:00+02:00"} {
+		if m, ok := parseModified(s); !ok || m.UTC().Format(time.RFC3339) != "2014-06-01T12:00:00Z" {
+			t.Errorf("parseModified(%q) => %v, %v", s, m, ok)
+		}
+	}
+	if _, ok := parseModified("last tuesday"); ok {
+		t.Errorf("expected invalid date to fail")
+	}
+}
+
+func TestDataETag(t *testing.T) {
+	data := []map[string]string{{"p": "http://example.org/p"}}
+	etag := dataETag(".nt", data)
+	if !strings.HasPrefix(etag, `W/"`) || etag != dataETag(".nt", data) {
+		t.Errorf("expected the same weak entity tag of the same data, got %s", etag)
+	}
+	if etag == dataETag(".ttl", data) {
+		t.Errorf("expected another entity tag of other data")
+	}
+}
@@ -8,22 +8,78 @@ type Config struct {
 	QuadStore  quadStore
 	UI         userInterface
 	Vocab      vocabulary
+	Cache      resultsCache
+	Admin      admin
 }
 
 type quadStore struct {
-	Endpoint     string
-	OpenTimeout  int
-	ReadTimeout  int
-	ResultsLimit int
+	Endpoint           string
+	Endpoints          []string
+	EndpointSelection  string
+	Protocol           string
+	Dialect            string
+	TimeoutHint        bool
+	ResultsFormats     []string
+	DefaultGraphs      []string
+	NamedGraphs        []string
+	HedgeAfter         int
+	Retries            int
+	RetryBackoff       int
+	BreakerThreshold   int
+	BreakerCooldown    int
+	MaxConcurrent      int
+	QueueSize          int
+	QueueTimeout       int
+	OpenTimeout        int
+	ReadTimeout        int
+	MaxIdleConns       int
+	IdleTimeout        int
+	DisableCompression bool
+	DisableHTTP2       bool
+	Auth               string
+	Username           string
+	Password           string
+	Token              string
+	ClientCert         string
+	ClientKey          string
+	CACert             string
+	ResultsLimit       int
+	OutgoingLimit      int
+	IncomingLimit      int
+	MaxResponseSize    int
+	BlankNodeDepth     int
+	BlankNodeIRI       string
+}
+
+type resultsCache struct {
+	Size         int
+	TTL          int
+	TTLs         map[string]int
+	HTMLMaxAge   int
+	DataMaxAge   int
+	StaleDir     string
+	StaleMaxSize int
+	StaleMaxAge  int
 }
 
 type userInterface struct {
-	FetchLiterals   bool
-	ShowImages      bool
-	NumImages       int
-	ImagePredicates []string
-	TitlePredicates []string
-	RootRedirectTo  string
+	FetchLiterals     bool
+	ShowImages        bool
+	NumImages         int
+	ImagePredicates   []string
+	TitlePredicates   []string
+	ModifiedPredicate string
+	RootRedirectTo    string
+	ResolveLabels     bool
+	LabelCacheSize    int
+	LabelCacheTTL     int
+	Languages         []string
+	CollapseLanguages bool
+}
+
+type admin struct {
+	Token           string
+	WarmConcurrency int
 }
 
 type vocabulary struct {
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// window is a page of the outgoing and incoming triples of a resource. Both
+// directions are paged independently, with their own limit.
+type window struct {
+	// Page is the 1-based page number, or 0 when paging by an explicit offset.
+	Page      int
+	OutOffset int
+	OutLimit  int
+	InOffset  int
+	InLimit   int
+}
+
+// intParam returns the value of an integer form parameter, or the default
+// value if missing or invalid.
+func intParam(r *http.Request, name string, def int) int {
+	v, err := strconv.Atoi(r.FormValue(name))
+	if err != nil || v < 0 {
+		return def
+	}
+	return v
+}
+
+// defaultWindow returns the window of the first page, with the configured
+// limits.
+func defaultWindow() window {
+	return window{
+		Page:     1,
+		OutLimit: conf.QuadStore.OutgoingLimit,
+		InLimit:  conf.QuadStore.IncomingLimit,
+	}
+}
+
+// requestWindow returns the window given by the page, offset and limit
+// parameters of the request.
+//
+// When paging by ?page=, each direction advances by its configured limit.
+// When paging by ?offset=, both directions share the same offset and the
+// smallest of the limits.
+func requestWindow(r *http.Request) window {
+	outLimit, inLimit := conf.QuadStore.OutgoingLimit, conf.QuadStore.IncomingLimit
+	if l := intParam(r, "limit", 0); l > 0 {
+		if l < outLimit {
+			outLimit = l
+		}
+		if l < inLimit {
+			inLimit = l
+		}
+	}
+
+	if r.FormValue("offset") != "" {
+		offset := intParam(r, "offset", 0)
+		limit := outLimit
+		if inLimit < limit {
+			limit = inLimit
+		}
+		return window{OutOffset: offset, OutLimit: limit, InOffset: offset, InLimit: limit}
+	}
+
+	page := intParam(r, "page", 1)
+	if page < 1 {
+		page = 1
+	}
+	return window{
+		Page:      page,
+		OutOffset: (page - 1) * outLimit,
+		OutLimit:  outLimit,
+		InOffset:  (page - 1) * inLimit,
+		InLimit:   inLimit,
+	}
+}
+
+// first returns true if the window is on the first page.
+func (w window) first() bool {
+	return w.OutOffset == 0 && w.InOffset == 0
+}
+
+// next returns the following window.
+func (w window) next() window {
+	if w.Page > 0 {
+		w.Page++
+	}
+	w.OutOffset += w.OutLimit
+	w.InOffset += w.InLimit
+	return w
+}
+
+// prev returns the preceding window.
+func (w window) prev() window {
+	if w.Page > 1 {
+		w.Page--
+	}
+	w.OutOffset -= w.OutLimit
+	if w.OutOffset < 0 {
+		w.OutOffset = 0
+	}
+	w.InOffset -= w.InLimit
+	if w.InOffset < 0 {
+		w.InOffset = 0
+	}
+	return w
+}
+
+// url returns the URL of the request, with its paging parameters replaced by
+// the ones of the window.
+func (w window) url(r *http.Request) string {
+	u := *r.URL
+	params := u.Query()
+	params.Del("page")
+	params.Del("offset")
+	if w.Page > 0 {
+		if w.Page > 1 {
+			params.Set("page", strconv.Itoa(w.Page))
+		}
+	} else {
+		params.Set("offset", strconv.Itoa(w.OutOffset))
+	}
+	u.RawQuery = params.Encode()
+	return u.RequestURI()
+}
+
+// resourceQuery holds the parameters of the queries describing a resource.
+// Predicate and Graph are optional filters. BlankNodes are the levels of
+// nested blank nodes to expand, in the queries for outgoing triples; each
+// level is described by at most Limit triples.
+type resourceQuery struct {
+	URI        string
+	Limit      int
+	Offset     int
+	Predicate  string
+	Graph      string
+	BlankNodes [][]blankStep
+}
+
+// description is a page of the triples describing a resource.
+type description struct {
+	URI string
+	window
+	restriction
+	// Outgoing are solutions binding ?g ?p ?o, where the URI is the subject.
+	Outgoing []map[string]rdf.Term
+	// Incoming are solutions binding ?g ?s ?p, where the URI is the object.
+	Incoming []map[string]rdf.Term
+	// MoreOutgoing and MoreIncoming are true if there are triples
+	// beyond the window.
+	MoreOutgoing bool
+	MoreIncoming bool
+	// BlankNodes are solutions binding ?p ?o of the blank nodes among the
+	// outgoing objects, and their nested blank nodes, keyed by label.
+	BlankNodes map[string][]map[string]rdf.Term
+	// CachedAt is the time the description was stored, if served from the
+	// stale store because the SPARQL endpoint failed.
+	CachedAt time.Time
+	// Truncated is true if a response exceeded the size limit, and the
+	// description holds the triples read until then.
+	Truncated bool
+}
+
+// hasNext returns true if there are triples in either direction after the
+// window.
+func (d *description) hasNext() bool {
+	return d.MoreOutgoing || d.MoreIncoming
+}
+
+// partial returns true if the description does not hold all the triples of
+// the resource.
+func (d *description) partial() bool {
+	return d.hasNext() || !d.first()
+}
+
+// querySolutions runs the query with the given tag and parameters against the
+// SPARQL endpoint, and returns its solutions. The query is canceled when the
+// context is.
+func querySolutions(ctx context.Context, tag string, params interface{}) ([]map[string]rdf.Term, error) {
+	solutions, _, err := querySolutionsAt(ctx, tag, params)
+	return solutions, err
+}
+
+// querySolutionsAt is like querySolutions, but also returns the time the
+// solutions were stored, if served from the stale store. If the response
+// exceeds the size limit, the solutions decoded until then are returned with
+// its error.
+func querySolutionsAt(ctx context.Context, tag string, params interface{}) ([]map[string]rdf.Term, time.Time, error) {
+	q, err := qBank.Prepare(tag, params)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := repo.Query(ctx, tag, q, "results")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Close()
+
+	solutions, err := parseSolutions(resp)
+	if tooLarge(err) {
+		return solutions, cachedAt(resp), err
+	}
+	if err != nil {
+		return nil, time.Time{}, malformedError(err)
+	}
+	return solutions, cachedAt(resp), nil
+}
+
+// staleTime returns the earliest of the non-zero times.
+func staleTime(times ...time.Time) time.Time {
+	var earliest time.Time
+	for _, t := range times {
+		if !t.IsZero() && (earliest.IsZero() || t.Before(earliest)) {
+			earliest = t
+		}
+	}
+	return earliest
+}
+
+// intValue returns the value of an integer literal, or 0 if the term is
+// something else.
+func intValue(t rdf.Term) int {
+	l, ok := t.(rdf.Literal)
+	if !ok {
+		return 0
+	}
+	v, _ := l.Typed()
+	i, _ := v.(int)
+	return i
+}
+
+// countSolutions returns the total number of triples where the URI is the
+// subject and object, respectively, within the restriction.
+func countSolutions(ctx context.Context, uri string, rs restriction) (maxS, maxO int, err error) {
+	solutions, err := querySolutions(ctx, "count", resourceQuery{URI: uri, Predicate: rs.Predicate, Graph: rs.Graph})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(solutions) == 0 {
+		return 0, 0, malformedError(errors.New("missing solution counts"))
+	}
+	return intValue(solutions[0]["maxS"]), intValue(solutions[0]["maxO"]), nil
+}
+
+// describe fetches the outgoing and incoming triples of the resource within
+// the window and restriction. The two directions are queried concurrently.
+// A direction exceeding the size limit is described by the triples read until
+// then, unless there are none.
+func describe(ctx context.Context, uri string, w window, rs restriction) (*description, error) {
+	d := description{URI: uri, window: w, restriction: rs}
+	var wg sync.WaitGroup
+	var outErr, inErr error
+	var outAt, inAt time.Time
+
+	if rs.includes(outgoing) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Fetch one more than the limit, to find out if there are more
+			var solutions []map[string]rdf.Term
+			solutions, outAt, outErr = querySolutionsAt(ctx, "outgoing", resourceQuery{
+				uri, w.OutLimit + 1, w.OutOffset, rs.Predicate, rs.Graph,
+				blankLevels(conf.QuadStore.BlankNodeDepth)})
+			d.Outgoing, d.BlankNodes = splitBlankNodes(solutions)
+			if len(d.Outgoing) > w.OutLimit {
+				d.Outgoing, d.MoreOutgoing = d.Outgoing[:w.OutLimit], true
+			}
+		}()
+	}
+	if rs.includes(incoming) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Incoming, inAt, inErr = querySolutionsAt(ctx, "incoming", resourceQuery{
+				uri, w.InLimit + 1, w.InOffset, rs.Predicate, rs.Graph, nil})
+			if len(d.Incoming) > w.InLimit {
+				d.Incoming, d.MoreIncoming = d.Incoming[:w.InLimit], true
+			}
+		}()
+	}
+	wg.Wait()
+
+	if tooLarge(outErr) && len(d.Outgoing) > 0 {
+		outErr, d.Truncated = nil, true
+	}
+	if tooLarge(inErr) && len(d.Incoming) > 0 {
+		inErr, d.Truncated = nil, true
+	}
+	if outErr != nil {
+		return nil, outErr
+	}
+	if inErr != nil {
+		return nil, inErr
+	}
+	d.CachedAt = staleTime(outAt, inAt)
+	return &d, nil
+}
+
+// setLinkHeaders sets the Link headers pointing to the next and previous
+// pages of the description.
+func setLinkHeaders(w http.ResponseWriter, r *http.Request, win window, hasNext bool) {
+	if hasNext {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"next\"", win.next().url(r)))
+	}
+	if !win.first() {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"prev\"", win.prev().url(r)))
+	}
+}
+
+// queryQuads runs the construct query with the given tag and parameters
+// against the SPARQL endpoint, and returns the resulting quads, and the time
+// they were stored if served from the stale store. The query is canceled when
+// the context is. The quads are decoded as the response is read; if it
+// exceeds the size limit, the quads decoded until then are returned with its
+// error.
+func queryQuads(ctx context.Context, tag string, params interface{}) ([]rdf.Quad, time.Time, error) {
+	q, err := qBank.Prepare(tag, params)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := repo.Query(ctx, tag, q, "nquads")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Close()
+
+	// The decoder takes a failed read for the end of the input
+	body := &errReader{r: resp}
+	dec := rdf.NewQuadDecoder(body, rdf.NQuads)
+	var quads []rdf.Quad
+	for {
+		q, err := dec.Decode()
+		if err != nil && body.err != nil {
+			err = body.err
+		}
+		if err == io.EOF {
+			return quads, cachedAt(resp), nil
+		}
+		if tooLarge(err) {
+			return quads, cachedAt(resp), err
+		}
+		if err != nil {
+			return nil, time.Time{}, malformedError(err)
+		}
+		quads = append(quads, q)
+	}
+}
+
+// solutionQuads returns the quads of the solutions of the outgoing or
+// incoming query of the resource. The resource is the subject of the
+// solutions binding ?g ?p ?o, unless ?b binds the blank node it describes,
+// and the object of the solutions binding ?g ?s ?p.
+func solutionQuads(uri string, solutions []map[string]rdf.Term) ([]rdf.Quad, error) {
+	res, err := rdf.NewIRI(uri)
+	if err != nil {
+		return nil, err
+	}
+	quads := make([]rdf.Quad, 0, len(solutions))
+	for _, m := range solutions {
+		s, o := m["s"], m["o"]
+		if s == nil {
+			s = m["b"]
+		}
+		if s == nil {
+			s = res
+		}
+		if o == nil {
+			o = res
+		}
+		subj, okS := s.(rdf.Subject)
+		pred, okP := m["p"].(rdf.Predicate)
+		obj, okO := o.(rdf.Object)
+		g, okG := m["g"].(rdf.Context)
+		if !okS || !okP || !okO || !okG {
+			return nil, fmt.Errorf("solution is not a quad: %v", m)
+		}
+		quads = append(quads, rdf.Quad{Triple: rdf.Triple{Subj: subj, Pred: pred, Obj: obj}, Ctx: g})
+	}
+	return quads, nil
+}
+
+// resourceQuads returns the quads of the outgoing or incoming triples of the
+// resource, and the time they were stored if served from the stale store.
+// They are constructed by the store with the construct query of the dialect,
+// if it has one; else they are built from the solutions of the select query.
+func resourceQuads(ctx context.Context, selectTag, constructTag string, params resourceQuery) ([]rdf.Quad, time.Time, error) {
+	if _, ok := qBank[constructTag]; ok {
+		return queryQuads(ctx, constructTag, params)
+	}
+	solutions, storedAt, err := querySolutionsAt(ctx, selectTag, params)
+	quads, qErr := solutionQuads(params.URI, solutions)
+	if qErr != nil {
+		return nil, time.Time{}, malformedError(qErr)
+	}
+	return quads, storedAt, err
+}
+
+// describeQuads fetches the outgoing and incoming quads of the resource within
+// the window and restriction, and reports if there are more quads after it.
+// The outgoing quads include the description of its blank nodes. If served
+// from the stale store, the time the quads were stored is returned.
+// The two directions are queried concurrently. If a direction exceeds the size
+// limit, the quads read until then are returned with its error.
+func describeQuads(ctx context.Context, uri string, w window, rs restriction) ([]rdf.Quad, bool, time.Time, error) {
+	var out, in []rdf.Quad
+	var outErr, inErr error
+	var outAt, inAt time.Time
+	var wg sync.WaitGroup
+
+	if rs.includes(outgoing) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, outAt, outErr = resourceQuads(ctx, "outgoing", "constructOutgoing", resourceQuery{
+				uri, w.OutLimit + 1, w.OutOffset, rs.Predicate, rs.Graph,
+				blankLevels(conf.QuadStore.BlankNodeDepth)})
+		}()
+	}
+	if rs.includes(incoming) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in, inAt, inErr = resourceQuads(ctx, "incoming", "constructIncoming", resourceQuery{
+				uri, w.InLimit + 1, w.InOffset, rs.Predicate, rs.Graph, nil})
+		}()
+	}
+	wg.Wait()
+
+	var err error
+	if tooLarge(outErr) {
+		err, outErr = outErr, nil
+	}
+	if tooLarge(inErr) {
+		err, inErr = inErr, nil
+	}
+	if outErr != nil {
+		return nil, false, time.Time{}, outErr
+	}
+	if inErr != nil {
+		return nil, false, time.Time{}, inErr
+	}
+
+	// The outgoing quads include the quads of its blank nodes
+	out, more := limitQuads(uri, out, w.OutLimit)
+	if len(in) > w.InLimit {
+		in, more = in[:w.InLimit], true
+	}
+	return append(out, in...), more, staleTime(outAt, inAt), err
+}
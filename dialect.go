@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/knakk/sparql"
+)
+
+// dialect is a profile of a SPARQL store, supplying the queries and request
+// parameters it needs beyond standard SPARQL 1.1.
+type dialect struct {
+	Name string
+	// Queries are the tagged queries replacing the standard ones.
+	Queries string
+	// TimeoutParam is the request parameter hinting the store to give up
+	// the query after the timeout, in units of TimeoutUnit; if supported.
+	TimeoutParam string
+	TimeoutUnit  time.Duration
+	// PartialHeader is the response header by which the store flags the
+	// partial results of a query given up after the timeout hint.
+	PartialHeader string
+	// BlankNodeIRI is the IRI by which the store identifies blank nodes,
+	// used unless configured.
+	BlankNodeIRI string
+}
+
+// constructQuads are the queries of the outgoing and incoming quads of a
+// resource, for the stores supporting GRAPH in CONSTRUCT templates; an
+// extension of SPARQL 1.1. Without them, the quads are built from the
+// solutions of the outgoing and incoming queries.
+const constructQuads = `
+# tag: constructOutgoing
+CONSTRUCT { GRAPH ?g { <{{.URI}}> ?p ?o . ?b ?bp ?bo } }
+WHERE { { SELECT ?g ?p ?o
+          WHERE { GRAPH ?g { <{{.URI}}> ?p ?o }{{if .Predicate}} FILTER (?p = <{{.Predicate}}>){{end}}{{if .Graph}} FILTER (?g = <{{.Graph}}>){{end}} }
+          ORDER BY ?g ?p ?o
+          LIMIT {{.Limit}}{{if .Offset}} OFFSET {{.Offset}}{{end}} }{{range .BlankNodes}}
+        UNION
+        { SELECT ?g ?b ?bp ?bo
+          WHERE { { SELECT ?g (?o AS ?b0)
+                    WHERE { GRAPH ?g { <{{$.URI}}> ?p ?o }{{if $.Predicate}} FILTER (?p = <{{$.Predicate}}>){{end}}{{if $.Graph}} FILTER (?g = <{{$.Graph}}>){{end}} }
+                    ORDER BY ?g ?p ?o
+                    LIMIT {{$.Limit}}{{if $.Offset}} OFFSET {{$.Offset}}{{end}} }
+                  FILTER isBlank(?b0){{range .}}
+                  GRAPH ?g { ?b{{.From}} ?p{{.To}} ?b{{.To}} } FILTER isBlank(?b{{.To}}){{end}}
+                  GRAPH ?g { ?b{{len .}} ?bp ?bo }
+                  BIND (?b{{len .}} AS ?b) }
+          ORDER BY ?g ?b ?bp ?bo
+          LIMIT {{$.Limit}} }{{end}} }
+
+# tag: constructIncoming
+CONSTRUCT { GRAPH ?g { ?s ?p <{{.URI}}> } }
+WHERE { GRAPH ?g { ?s ?p <{{.URI}}> }{{if .Predicate}} FILTER (?p = <{{.Predicate}}>){{end}}{{if .Graph}} FILTER (?g = <{{.Graph}}>){{end}} }
+ORDER BY ?g ?s ?p
+LIMIT {{.Limit}}{{if .Offset}} OFFSET {{.Offset}}{{end}}
+`
+
+// dialects are the known profiles, by name.
+var dialects = map[string]dialect{
+	"sparql11": {
+		Name: "sparql11",
+	},
+	"virtuoso": {
+		Name: "virtuoso",
+		Queries: constructQuads + `
+# tag: search
+SELECT DISTINCT ?s ?p ?o
+WHERE { GRAPH ?g { ?s ?p ?o . ?o bif:contains {{.Text}} } }
+LIMIT {{.Limit}}
+`,
+		TimeoutParam:  "timeout",
+		TimeoutUnit:   time.Millisecond,
+		PartialHeader: "X-SQL-State",
+		BlankNodeIRI:  "nodeID://%s",
+	},
+	"fuseki": {
+		Name: "fuseki",
+		Queries: constructQuads + `
+# tag: search
+PREFIX text: <http://jena.apache.org/text#>
+SELECT DISTINCT ?s ?p ?o
+WHERE { ?s text:query {{.Text}} .
+        GRAPH ?g { ?s ?p ?o } FILTER isLiteral(?o) }
+LIMIT {{.Limit}}
+`,
+		TimeoutParam: "timeout",
+		TimeoutUnit:  time.Second,
+	},
+	"graphdb": {
+		Name: "graphdb",
+		Queries: `
+# tag: search
+PREFIX luc: <http://www.ontotext.com/owlim/lucene#>
+SELECT DISTINCT ?s ?p ?o
+WHERE { ?o luc: {{.Text}} .
+        GRAPH ?g { ?s ?p ?o } }
+LIMIT {{.Limit}}
+`,
+		TimeoutParam: "timeout",
+		TimeoutUnit:  time.Second,
+	},
+	"blazegraph": {
+		Name: "blazegraph",
+		Queries: `
+# tag: search
+PREFIX bds: <http://www.bigdata.com/rdf/search#>
+SELECT DISTINCT ?s ?p ?o
+WHERE { ?o bds:search {{.Text}} .
+        GRAPH ?g { ?s ?p ?o } }
+LIMIT {{.Limit}}
+`,
+		TimeoutParam: "maxQueryTimeMillis",
+		TimeoutUnit:  time.Millisecond,
+	},
+}
+
+// lookupDialect returns the profile with the given name, or the standard
+// SPARQL 1.1 profile if there is none.
+func lookupDialect(name string) dialect {
+	if d, ok := dialects[strings.ToLower(name)]; ok {
+		return d
+	}
+	if name != "" {
+		log.Printf("unknown SPARQL dialect %q; using standard SPARQL 1.1", name)
+	}
+	return dialects["sparql11"]
+}
+
+// bank returns the query bank of the dialect; the standard queries, replaced
+// by the ones of the dialect.
+func (d dialect) bank(standard string) sparql.Bank {
+	b := sparql.LoadBank(bytes.NewBufferString(standard))
+	for tag, q := range sparql.LoadBank(bytes.NewBufferString(d.Queries)) {
+		b[tag] = q
+	}
+	return b
+}
+
+// searchQuery is the parameters of the search query. Text is interpolated as
+// is, and must be quoted with quoteLiteral.
+type searchQuery struct {
+	Text  string
+	Limit int
+}
+
+// quoteLiteral returns the string as a quoted SPARQL string literal.
+func quoteLiteral(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// timeoutParams returns the request parameters hinting the store of the
+// timeout of a query, if supported.
+func (d dialect) timeoutParams(timeout time.Duration) url.Values {
+	params := url.Values{}
+	if d.TimeoutParam == "" || timeout <= 0 {
+		return params
+	}
+	n := int64((timeout + d.TimeoutUnit - 1) / d.TimeoutUnit)
+	params.Set(d.TimeoutParam, strconv.FormatInt(n, 10))
+	return params
+}
+
+// partialKey is the context key of the flag raised when a query is answered
+// with partial results.
+type partialKey struct{}
+
+// withPartialFlag returns a context flagging the queries answered with
+// partial results, and a function reporting whether any was.
+func withPartialFlag(ctx context.Context) (context.Context, func() bool) {
+	flag := new(int32)
+	return context.WithValue(ctx, partialKey{}, flag), func() bool {
+		return atomic.LoadInt32(flag) != 0
+	}
+}
+
+// flagPartial raises the flag of the context if the response has the partial
+// results of a query, as told by the PartialHeader of the dialect.
+func (d dialect) flagPartial(ctx context.Context, resp *http.Response) {
+	if d.PartialHeader == "" || resp.Header.Get(d.PartialHeader) == "" {
+		return
+	}
+	if flag, ok := ctx.Value(partialKey{}).(*int32); ok {
+		atomic.StoreInt32(flag, 1)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookupDialect(t *testing.T) {
+	if d := lookupDialect("Virtuoso"); d.Name != "virtuoso" {
+		t.Errorf("expected virtuoso profile, got %q", d.Name)
+	}
+	for _, name := range []string{"", "allegrograph"} {
+		if d := lookupDialect(name); d.Name != "sparql11" {
+			t.Errorf("lookupDialect(%q) => %q; want standard profile", name, d.Name)
+		}
+	}
+}
+
+func TestDialectBank(t *testing.T) {
+	tests := []struct {
+		dialect   string
+		search    string
+		construct bool
+	}{
+		{"sparql11", "CONTAINS(LCASE(STR(?o)), LCASE(\"ibsen\"))", false},
+		{"virtuoso", "?o bif:contains \"ibsen\"", true},
+		{"fuseki", "?s text:query \"ibsen\"", true},
+		{"graphdb", "?o luc: \"ibsen\"", false},
+		{"blazegraph", "?o bds:search \"ibsen\"", false},
+	}
+	for _, tt := range tests {
+		b := dialects[tt.dialect].bank(queries)
+		q, err := b.Prepare("count", resourceQuery{URI: "http://example.org/r"})
+		if err != nil || !strings.Contains(q, "(COUNT(?s) AS ?maxO)") {
+			t.Errorf("%s: expected standard count query, got %s, %v", tt.dialect, q, err)
+		}
+		q, err = b.Prepare("search", searchQuery{Text: quoteLiteral("ibsen"), Limit: 10})
+		if err != nil || !strings.Contains(q, tt.search) || !strings.Contains(q, "LIMIT 10") {
+			t.Errorf("%s: expected search query with %s, got %s, %v", tt.dialect, tt.search, q, err)
+		}
+		q, err = b.Prepare("constructIncoming", resourceQuery{URI: "http://example.org/r", Limit: 10})
+		if tt.construct && (err != nil || !strings.Contains(q, "CONSTRUCT { GRAPH ?g { ?s ?p <http://example.org/r> } }")) {
+			t.Errorf("%s: expected construct query of incoming quads, got %s, %v", tt.dialect, q, err)
+		}
+		if _, ok := b["constructOutgoing"]; ok != tt.construct {
+			t.Errorf("%s: construct query of quads => %v; want %v", tt.dialect, ok, tt.construct)
+		}
+	}
+	if len(tests) != len(dialects) {
+		t.Errorf("tested %d dialects of %d", len(tests), len(dialects))
+	}
+
+	d := dialect{Queries: "# tag: count\nSELECT 1 {}\n"}
+	if q, err := d.bank(queries).Prepare("count", resourceQuery{}); err != nil || q != "SELECT 1 {}" {
+		t.Errorf("expected count query of the dialect, got %q, %v", q, err)
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	if got, want := quoteLiteral("a \"b\"\\\n"), `"a \"b\"\\\n"`; got != want {
+		t.Errorf("quoteLiteral => %s; want %s", got, want)
+	}
+}
+
+func TestDialectTimeoutParams(t *testing.T) {
+	tests := []struct {
+		dialect string
+		timeout time.Duration
+		want    string
+	}{
+		{"virtuoso", 5 * time.Second, "timeout=5000"},
+		{"fuseki", 4500 * time.Millisecond, "timeout=5"},
+		{"blazegraph", time.Second, "maxQueryTimeMillis=1000"},
+		{"sparql11", time.Second, ""},
+		{"virtuoso", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := dialects[tt.dialect].timeoutParams(tt.timeout).Encode(); got != tt.want {
+			t.Errorf("%s timeoutParams(%v) => %q; want %q", tt.dialect, tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestQueryPartialResults(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.FormValue("timeout") == "" {
+			t.Errorf("expected timeout hint, got %q", r.Form.Encode())
+		}
+		w.Header().Set("X-SQL-State", "S1TAT")
+		fmt.Fprint(w, "partial")
+	}))
+	defer ts.Close()
+
+	r := newRepo(newEndpointPool([]string{ts.URL}, 5, time.Second), clientOptions{OpenTimeout: time.Second, ReadTimeout: time.Second})
+	r.protocol = "form"
+	r.dialect = dialects["virtuoso"]
+	r.timeoutHint = true
+	r.cache = newQueryCache(10, 60, nil)
+
+	for i := 0; i < 2; i++ {
+		resp, err := r.Query(context.Background(), "outgoing", "SELECT * WHERE { ?s ?p ?o }", "results")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp)
+		resp.Close()
+		if string(body) != "partial" {
+			t.Errorf("expected %q, got %q", "partial", body)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected partial results not to be cached; endpoint queried %d times", calls)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// selectPrimary tries the endpoints in the configured order.
+	selectPrimary = "primary"
+	// selectRoundRobin spreads the queries over the endpoints in turn.
+	selectRoundRobin = "roundrobin"
+)
+
+// retryable returns true if the query failed because of the endpoint, so it
+// may succeed on another, or later; a connection error, a timeout or a server
+// error. Other errors, such as a failure to build the request, are local to
+// Fenster and not retried.
+func retryable(err error) bool {
+	e, ok := err.(queryError)
+	if !ok {
+		return false
+	}
+	switch e.Kind {
+	case errConnect, errTimeout:
+		return true
+	case errQuery:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// endpoint is a SPARQL endpoint, with the circuit breaker tracking its
+// health.
+type endpoint struct {
+	URL     string
+	breaker *breaker
+
+	mu        sync.Mutex
+	lastError string
+}
+
+// done records the outcome of a query to the endpoint.
+func (e *endpoint) done(err error) {
+	if err == nil || !retryable(err) {
+		e.breaker.success()
+		return
+	}
+	e.breaker.failure()
+	e.mu.Lock()
+	e.lastError = err.Error()
+	e.mu.Unlock()
+}
+
+// endpointStatus is the health of an endpoint, as exported by statusHandler.
+type endpointStatus struct {
+	URL       string
+	State     string
+	Trips     int
+	LastError string
+}
+
+// endpointPool is the SPARQL endpoints serving the same data. Queries failing
+// on one endpoint are retried on the next, and optionally hedged by a second
+// request to the next endpoint if the first is slow. Endpoints failing
+// repeatedly are not queried until their circuit breaker has cooled down.
+type endpointPool struct {
+	endpoints []*endpoint
+	// selection is selectPrimary or selectRoundRobin.
+	selection string
+	// hedgeAfter is the latency after which a query is hedged, or 0 to not
+	// hedge queries.
+	hedgeAfter time.Duration
+	// retries is the number of times a query failing on all the endpoints
+	// is retried, after a backoff doubling from retryBackoff.
+	retries      int
+	retryBackoff time.Duration
+	next         uint32
+
+	failovers metrics.Counter
+	hedged    metrics.Counter
+	retried   metrics.Counter
+	rejected  metrics.Counter
+	aborted   metrics.Counter
+}
+
+// newEndpointPool returns an endpointPool of the endpoint URLs, selecting the
+// primary endpoint first. The circuit breakers of the endpoints open after
+// threshold consecutive failures, for the duration of cooldown.
+func newEndpointPool(urls []string, threshold int, cooldown time.Duration) *endpointPool {
+	p := &endpointPool{
+		selection: selectPrimary,
+		failovers: metrics.GetOrRegisterCounter("endpoints.failovers", metrics.DefaultRegistry),
+		hedged:    metrics.GetOrRegisterCounter("endpoints.hedged", metrics.DefaultRegistry),
+		retried:   metrics.GetOrRegisterCounter("endpoints.retried", metrics.DefaultRegistry),
+		rejected:  metrics.GetOrRegisterCounter("endpoints.rejected", metrics.DefaultRegistry),
+		aborted:   metrics.GetOrRegisterCounter("endpoints.aborted", metrics.DefaultRegistry),
+	}
+	trips := metrics.GetOrRegisterCounter("endpoints.trips", metrics.DefaultRegistry)
+	for _, u := range urls {
+		b := newBreaker(threshold, cooldown)
+		b.onTrip = func() { trips.Inc(1) }
+		p.endpoints = append(p.endpoints, &endpoint{URL: u, breaker: b})
+	}
+	return p
+}
+
+// picker returns the endpoints a query should try, one by one, in the order
+// of the selection strategy; skipping the ones with an open circuit. When
+// there are no more, it returns the error to fail with if none were picked.
+func (p *endpointPool) picker() func() (*endpoint, error) {
+	n := len(p.endpoints)
+	start := 0
+	if p.selection == selectRoundRobin && n > 0 {
+		start = int(atomic.AddUint32(&p.next, 1)-1) % n
+	}
+	i, picked := 0, 0
+	var wait time.Duration
+	return func() (*endpoint, error) {
+		for ; i < n; i++ {
+			e := p.endpoints[(start+i)%n]
+			ok, w := e.breaker.allow()
+			if ok {
+				i++
+				picked++
+				return e, nil
+			}
+			if wait == 0 || w < wait {
+				wait = w
+			}
+		}
+		if picked == 0 {
+			if n == 0 {
+				return nil, fmt.Errorf("no SPARQL endpoint configured")
+			}
+			p.rejected.Inc(1)
+			return nil, circuitOpenError{wait}
+		}
+		return nil, nil
+	}
+}
+
+// Status returns the health of the endpoints.
+func (p *endpointPool) Status() []endpointStatus {
+	var s []endpointStatus
+	for _, e := range p.endpoints {
+		state, trips := e.breaker.status()
+		e.mu.Lock()
+		s = append(s, endpointStatus{e.URL, state, trips, e.lastError})
+		e.mu.Unlock()
+	}
+	return s
+}
+
+// queryFunc sends a query to the endpoint URL and returns the response body.
+type queryFunc func(ctx context.Context, url string) (io.ReadCloser, error)
+
+// Do runs the query against the endpoints, and retries it with a jittered
+// exponential backoff if it fails on all of them for a transient reason.
+// When the context is canceled, the requests in flight are aborted and the
+// error of the context is returned.
+func (p *endpointPool) Do(ctx context.Context, query queryFunc) (io.ReadCloser, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := p.try(ctx, query)
+		if err == nil || !retryable(err) || attempt >= p.retries {
+			return body, err
+		}
+		p.retried.Inc(1)
+		select {
+		case <-time.After(backoff(p.retryBackoff, attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// abort records a request to the endpoint aborted because the context was
+// canceled, and returns the error of the context.
+func (p *endpointPool) abort(ctx context.Context, e *endpoint) error {
+	e.breaker.release()
+	p.aborted.Inc(1)
+	return ctx.Err()
+}
+
+// try runs the query against the endpoints in order, until one answers or
+// it fails for a reason not related to the endpoint.
+func (p *endpointPool) try(ctx context.Context, query queryFunc) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	next := p.picker()
+	if p.hedgeAfter > 0 && len(p.endpoints) > 1 {
+		return p.tryHedged(ctx, next, query)
+	}
+
+	var err error
+	for i := 0; ; i++ {
+		e, perr := next()
+		if e == nil {
+			if perr != nil {
+				return nil, perr
+			}
+			return nil, err
+		}
+		if i > 0 {
+			p.failovers.Inc(1)
+		}
+		var body io.ReadCloser
+		body, err = query(ctx, e.URL)
+		if err != nil && ctx.Err() != nil {
+			return nil, p.abort(ctx, e)
+		}
+		e.done(err)
+		if err == nil {
+			return body, nil
+		}
+		if !retryable(err) {
+			return nil, err
+		}
+	}
+}
+
+// cancelBody is a response body, canceling its request when closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// tryHedged runs the query against the first endpoint, and against the next
+// one as well if there is no answer within the hedging latency, using the
+// first answer. Failing queries are retried on the following endpoints.
+func (p *endpointPool) tryHedged(ctx context.Context, next func() (*endpoint, error), query queryFunc) (io.ReadCloser, error) {
+	type result struct {
+		body io.ReadCloser
+		err  error
+		e    *endpoint
+		i    int
+	}
+	results := make(chan result, len(p.endpoints))
+	var cancels []context.CancelFunc
+	launch := func() (bool, error) {
+		e, err := next()
+		if e == nil {
+			return false, err
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		i := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			body, err := query(ctx, e.URL)
+			results <- result{body, err, e, i}
+		}()
+		return true, nil
+	}
+
+	if ok, err := launch(); !ok {
+		return nil, err
+	}
+	timer := time.NewTimer(p.hedgeAfter)
+	defer timer.Stop()
+	pending := 1
+	var err error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if ctx.Err() != nil {
+				break
+			}
+			if ok, _ := launch(); ok {
+				p.hedged.Inc(1)
+				pending++
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				res.e.done(nil)
+				for i, cancel := range cancels {
+					if i != res.i {
+						cancel()
+					}
+				}
+				// Close the bodies of the requests answering too late
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						late := <-results
+						if late.err == nil {
+							late.body.Close()
+						}
+						late.e.breaker.release()
+					}
+				}(pending)
+				return cancelBody{res.body, cancels[res.i]}, nil
+			}
+			cancels[res.i]()
+			if ctx.Err() != nil {
+				err = p.abort(ctx, res.e)
+				continue
+			}
+			res.e.done(res.err)
+			err = res.err
+			if !retryable(err) {
+				if pending > 0 {
+					continue
+				}
+				return nil, err
+			}
+			if ok, _ := launch(); ok {
+				p.failovers.Inc(1)
+				pending++
+			}
+		}
+	}
+	return nil, err
+}
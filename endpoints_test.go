@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeQuery answers queries to the endpoint URLs with the given errors, or
+// with the URL as body, after the given delays.
+func fakeQuery(errs map[string]error, delays map[string]time.Duration) queryFunc {
+	return func(ctx context.Context, url string) (io.ReadCloser, error) {
+		select {
+		case <-time.After(delays[url]):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if err := errs[url]; err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(strings.NewReader(url)), nil
+	}
+}
+
+func answer(body io.ReadCloser, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	defer body.Close()
+	b, _ := ioutil.ReadAll(body)
+	return string(b)
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{queryError{Kind: errConnect}, true},
+		{queryError{Kind: errTimeout}, true},
+		{queryError{Kind: errQuery, StatusCode: 502}, true},
+		{queryError{Kind: errQuery, StatusCode: 400}, false},
+		{queryError{Kind: errMalformed}, false},
+		{circuitOpenError{}, false},
+		{context.Canceled, false},
+		{errors.New("error preparing http request"), false},
+	}
+
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) => %v; want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointPoolFailover(t *testing.T) {
+	p := newEndpointPool([]string{"a", "b", "c"}, 1, time.Minute)
+
+	q := fakeQuery(map[string]error{"a": queryError{Kind: errQuery, StatusCode: 503}}, nil)
+	if got := answer(p.Do(context.Background(), q)); got != "b" {
+		t.Errorf("expected failover to b, got %q", got)
+	}
+	// The circuit of the failing endpoint is open, until it has cooled down
+	q = fakeQuery(nil, nil)
+	if got := answer(p.Do(context.Background(), q)); got != "b" {
+		t.Errorf("expected failed endpoint to be avoided, got %q", got)
+	}
+
+	q = fakeQuery(map[string]error{"b": queryError{Kind: errQuery, StatusCode: 400}}, nil)
+	if got := answer(p.Do(context.Background(), q)); got != (queryError{Kind: errQuery, StatusCode: 400}).Error() {
+		t.Errorf("expected client error not to fail over, got %q", got)
+	}
+
+	q = fakeQuery(map[string]error{"b": queryError{Kind: errQuery, StatusCode: 500}, "c": queryError{Kind: errConnect, Message: "dial tcp: refused"}}, nil)
+	if got := answer(p.Do(context.Background(), q)); got != (queryError{Kind: errConnect, Message: "dial tcp: refused"}).Error() {
+		t.Errorf("expected last error when all endpoints fail, got %q", got)
+	}
+
+	// All circuits are open
+	_, err := p.Do(context.Background(), fakeQuery(nil, nil))
+	if _, ok := err.(circuitOpenError); !ok {
+		t.Errorf("expected query to fail fast with all circuits open, got %v", err)
+	}
+}
+
+func TestEndpointPoolRetries(t *testing.T) {
+	p := newEndpointPool([]string{"a"}, 0, time.Minute)
+	p.retries = 2
+	p.retryBackoff = time.Millisecond
+
+	calls := 0
+	q := func(ctx context.Context, url string) (io.ReadCloser, error) {
+		calls++
+		if calls < 3 {
+			return nil, queryError{Kind: errQuery, StatusCode: 503}
+		}
+		return ioutil.NopCloser(strings.NewReader(url)), nil
+	}
+	if got := answer(p.Do(context.Background(), q)); got != "a" || calls != 3 {
+		t.Errorf("expected query to succeed on the second retry, got %q after %d calls", got, calls)
+	}
+
+	calls = 0
+	q = func(ctx context.Context, url string) (io.ReadCloser, error) {
+		calls++
+		return nil, queryError{Kind: errQuery, StatusCode: 404}
+	}
+	p.Do(context.Background(), q)
+	if calls != 1 {
+		t.Errorf("expected client error not to be retried, got %d calls", calls)
+	}
+}
+
+func TestEndpointPoolRoundRobin(t *testing.T) {
+	p := newEndpointPool([]string{"a", "b"}, 5, time.Minute)
+	p.selection = selectRoundRobin
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, answer(p.Do(context.Background(), fakeQuery(nil, nil))))
+	}
+	if strings.Join(got, "") != "abab" {
+		t.Errorf("expected queries to alternate between endpoints, got %v", got)
+	}
+}
+
+func TestEndpointPoolHedging(t *testing.T) {
+	p := newEndpointPool([]string{"a", "b"}, 5, time.Minute)
+	p.hedgeAfter = 10 * time.Millisecond
+
+	q := fakeQuery(nil, map[string]time.Duration{"a": time.Second})
+	start := time.Now()
+	if got := answer(p.Do(context.Background(), q)); got != "b" {
+		t.Errorf("expected hedged request to answer first, got %q", got)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("expected slow request to be hedged")
+	}
+
+	q = fakeQuery(nil, map[string]time.Duration{"b": time.Second})
+	if got := answer(p.Do(context.Background(), q)); got != "a" {
+		t.Errorf("expected fast first request to answer, got %q", got)
+	}
+}
+
+func TestEndpointPoolCanceled(t *testing.T) {
+	p := newEndpointPool([]string{"a", "b"}, 1, time.Minute)
+	p.retries = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	q := fakeQuery(nil, map[string]time.Duration{"a": time.Second})
+	if _, err := p.Do(ctx, q); err != context.Canceled {
+		t.Errorf("expected query to be aborted, got %v", err)
+	}
+	// Aborted queries say nothing about the health of the endpoint
+	if got := answer(p.Do(context.Background(), fakeQuery(nil, nil))); got != "a" {
+		t.Errorf("expected aborted endpoint to be queried, got %q", got)
+	}
+
+	p.hedgeAfter = time.Millisecond
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	q = fakeQuery(nil, map[string]time.Duration{"a": time.Second, "b": time.Second})
+	if _, err := p.Do(ctx, q); err != context.Canceled {
+		t.Errorf("expected hedged query to be aborted, got %v", err)
+	}
+}
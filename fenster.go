@@ -23,23 +23,64 @@ import (
 const (
 	version = "0.3"
 	queries = `
-# tag: select
-SELECT *
-WHERE { GRAPH ?g { { <{{.URI}}> ?p ?o } UNION { ?s ?p <{{.URI}}> } } }
-LIMIT {{.Limit}}
+# tag: outgoing
+SELECT ?g ?p ?o ?b
+WHERE { { SELECT ?g ?p ?o
+          WHERE { GRAPH ?g { <{{.URI}}> ?p ?o }{{if .Predicate}} FILTER (?p = <{{.Predicate}}>){{end}}{{if .Graph}} FILTER (?g = <{{.Graph}}>){{end}} }
+          ORDER BY ?g ?p ?o
+          LIMIT {{.Limit}}{{if .Offset}} OFFSET {{.Offset}}{{end}} }{{range .BlankNodes}}
+        UNION
+        { SELECT ?g ?b ?p ?o
+          WHERE { { SELECT ?g (?o AS ?b0)
+                    WHERE { GRAPH ?g { <{{$.URI}}> ?p ?o }{{if $.Predicate}} FILTER (?p = <{{$.Predicate}}>){{end}}{{if $.Graph}} FILTER (?g = <{{$.Graph}}>){{end}} }
+                    ORDER BY ?g ?p ?o
+                    LIMIT {{$.Limit}}{{if $.Offset}} OFFSET {{$.Offset}}{{end}} }
+                  FILTER isBlank(?b0){{range .}}
+                  GRAPH ?g { ?b{{.From}} ?p{{.To}} ?b{{.To}} } FILTER isBlank(?b{{.To}}){{end}}
+                  GRAPH ?g { ?b{{len .}} ?p ?o }
+                  BIND (?b{{len .}} AS ?b) }
+          ORDER BY ?g ?b ?p ?o
+          LIMIT {{$.Limit}} }{{end}} }
+
+# tag: incoming
+SELECT ?g ?s ?p
+WHERE { GRAPH ?g { ?s ?p <{{.URI}}> }{{if .Predicate}} FILTER (?p = <{{.Predicate}}>){{end}}{{if .Graph}} FILTER (?g = <{{.Graph}}>){{end}} }
+ORDER BY ?g ?s ?p
+LIMIT {{.Limit}}{{if .Offset}} OFFSET {{.Offset}}{{end}}
 
 # tag: count
-SELECT COUNT(?s) AS ?maxO, COUNT(?o) as ?maxS
-WHERE { GRAPH ?g { { <{{.URI}}> ?p ?o } UNION { ?s ?p <{{.URI}}> } } }
-
-# tag: construct
-CONSTRUCT { GRAPH ?g { <{{.URI}}> ?p ?o . ?s ?p <{{.URI}}> } }
-WHERE { GRAPH ?g { { <{{.URI}}> ?p ?o } UNION { ?s ?p <{{.URI}}> } } }
+SELECT (COUNT(?s) AS ?maxO) (COUNT(?o) AS ?maxS)
+WHERE { GRAPH ?g { { <{{.URI}}> ?p ?o } UNION { ?s ?p <{{.URI}}> } }{{if .Predicate}} FILTER (?p = <{{.Predicate}}>){{end}}{{if .Graph}} FILTER (?g = <{{.Graph}}>){{end}} }
+
+# tag: summaryOutgoing
+SELECT ?g ?p (COUNT(*) AS ?n)
+WHERE { GRAPH ?g { <{{.URI}}> ?p ?o } }
+GROUP BY ?g ?p
+ORDER BY DESC(?n)
+
+# tag: summaryIncoming
+SELECT ?g ?p (COUNT(*) AS ?n)
+WHERE { GRAPH ?g { ?s ?p <{{.URI}}> } }
+GROUP BY ?g ?p
+ORDER BY DESC(?n)
+
+# tag: labels
+SELECT ?s ?p ?o
+WHERE { VALUES ?s { {{range .URIs}}<{{.}}> {{end}}}
+        VALUES ?p { {{range .Predicates}}<{{.}}> {{end}}}
+        GRAPH ?g { ?s ?p ?o }
+        FILTER isLiteral(?o) }
 
-#tag: literals
-SELECT DISTINCT ?p, ?o
+# tag: literals
+SELECT DISTINCT ?p ?o
 WHERE { <{{.URI}}> ?p ?o .
         FILTER isLiteral(?o) }
+
+# tag: search
+SELECT DISTINCT ?s ?p ?o
+WHERE { GRAPH ?g { ?s ?p ?o }
+        FILTER (isLiteral(?o) && CONTAINS(LCASE(STR(?o)), LCASE({{.Text}}))) }
+LIMIT {{.Limit}}
 `
 )
 
@@ -55,48 +96,42 @@ var (
 
 type mainHandler struct{}
 
-// rdfHandler serves the quads in TriG syntax
-// http://wifo5-03.informatik.uni-mannheim.de/bizer/trig/
-func rdfHandler(w http.ResponseWriter, r *http.Request) {
-	uri := conf.BaseURI + strings.TrimSuffix(r.URL.Path, ".rdf")
-	format := "rdf"
-
-	q, _ := qBank.Prepare("construct", struct{ URI string }{uri})
-	resp, err := repo.Query(conf.QuadStore.Endpoint, q, format)
-	if err != nil {
-		errorHandler(w, r, err.Error()+". Refresh to try again.\n\nYou can increase the timeout values in Fensters configuration file.", http.StatusInternalServerError)
+// rdfHandler serves the quads of a resource description in the given RDF
+// serialization format, streamed in chunks. If a response from the SPARQL
+// endpoint exceeded the size limit, the quads read until then are served,
+// with a Warning header.
+func rdfHandler(w http.ResponseWriter, r *http.Request, uri string, f rdfFormat) {
+	win := requestWindow(r)
+	quads, more, storedAt, err := describeQuads(r.Context(), uri, win, requestRestriction(r))
+	truncated := tooLarge(err)
+	if err != nil && (!truncated || len(quads) == 0) {
+		log.Printf("%s: %v", r.URL.Path, err)
+		errorHandler(w, r, upstreamErrorMessage(err), upstreamErrorStatus(w, err))
 		return
 	}
-	defer resp.Close()
 
-	w.Header().Set("Content-Type", "application/x-trig")
-	io.Copy(w, resp)
+	setLinkHeaders(w, r, win, more)
+	maxAge := setStaleHeaders(w, storedAt, conf.Cache.DataMaxAge)
+	if truncated {
+		w.Header().Add("Warning", `199 - "Response truncated at the size limit"`)
+	}
+	if checkConditional(w, r, dataETag(f.Suffix, quads), quadsLastModified(uri, quads), maxAge) {
+		return
+	}
+	w.Header().Set("Content-Type", f.MediaTypes[0]+"; charset=utf-8")
+	err = streamResponse(w, func(out io.Writer) error {
+		return f.encode(out, quads)
+	})
+	if err != nil {
+		log.Printf("%s: %v", r.URL.Path, err)
+	}
 }
 
-// jsonHandler serves the raw "application/sparql-results+json" results from
-// the SPARQL endpoint
+// jsonHandler serves the resource description as a JSON document; the same
+// as served by apiResourceHandler
 func jsonHandler(w http.ResponseWriter, r *http.Request) {
 	uri := conf.BaseURI + strings.TrimSuffix(r.URL.Path, ".json")
-	format := "json"
-
-	q, _ := qBank.Prepare("select",
-		struct {
-			URI   string
-			Limit int
-		}{uri, conf.QuadStore.ResultsLimit})
-
-	resp, err := repo.Query(conf.QuadStore.Endpoint, q, format)
-	if err != nil {
-		errorHandler(w, r,
-			err.Error()+`. Refresh to try again.\n\n
-			You can increase the timeout values in Fensters configuration file.`,
-			http.StatusInternalServerError)
-		return
-	}
-	defer resp.Close()
-
-	w.Header().Set("Content-Type", "application/json")
-	io.Copy(w, resp)
+	serveAPIResource(w, r, uri)
 }
 
 // mainHandler serves the resource HTML presentation, or dispatches to the
@@ -109,7 +144,6 @@ func (m mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var uri string
-	resolved := false
 	suffix := suffixRg.FindString(r.URL.Path)
 
 	switch suffix {
@@ -117,78 +151,119 @@ func (m mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		break
 	case ".html":
 		uri = conf.BaseURI + strings.TrimSuffix(r.URL.Path, ".html")
-		resolved = true
 	case ".json":
 		jsonHandler(w, r)
 		return
-	case ".rdf":
-		rdfHandler(w, r)
-		return
 	default:
+		if f, ok := findRDFFormat(suffix); ok {
+			rdfHandler(w, r, conf.BaseURI+strings.TrimSuffix(r.URL.Path, suffix), f)
+			return
+		}
 		errorHandler(w, r,
 			fmt.Sprintf("Unsupported output format: %s.\n\n"+
-				"Valid formats are: html, json, rdf", suffix[1:]), http.StatusBadRequest)
+				"Valid formats are:\n%s", suffix[1:], availableFormats(representations)),
+			http.StatusBadRequest)
 		return
 	}
 
 	// The URI should be an exclusive identifier of the resource; so we redirect
-	// to URI+.html
-	if !resolved {
-		http.Redirect(w, r, r.URL.Path+".html", http.StatusFound)
+	// to the representation best matching the Accept header.
+	if suffix == "" {
+		w.Header().Add("Vary", "Accept")
+		rep, ok := negotiate(r.Header.Get("Accept"), representations)
+		if !ok {
+			errorHandler(w, r,
+				"None of the available formats are acceptable.\n\n"+
+					"Available formats are:\n"+availableFormats(representations),
+				http.StatusNotAcceptable)
+			return
+		}
+		target := r.URL.Path + rep.Suffix
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
 		return
 	}
 
-	q, _ := qBank.Prepare("select",
-		struct {
-			URI   string
-			Limit int
-		}{uri, conf.QuadStore.ResultsLimit})
+	htmlHandler(w, r, uri)
+}
 
-	resp, err := repo.Query(conf.QuadStore.Endpoint, q, "json")
+// htmlHandler serves the HTML presentation of the resource
+func htmlHandler(w http.ResponseWriter, r *http.Request, uri string) {
+	// Page loads are let through the limiter before other queries
+	r = r.WithContext(withPriority(r.Context(), priorityInteractive))
+	win := requestWindow(r)
+	d, err := describe(r.Context(), uri, win, requestRestriction(r))
 	if err != nil {
-		//println(err.Error())
-		errorHandler(w, r,
-			err.Error()+". Refresh to try again.\n\nYou can increase the timeout"+
-				" values in Fensters configuration file.", http.StatusInternalServerError)
+		log.Printf("%s: %v", r.URL.Path, err)
+		errorHandler(w, r, upstreamErrorMessage(err), upstreamErrorStatus(w, err))
 		return
 	}
-	defer resp.Close()
 
-	res, err := sparql.ParseJSON(resp)
-	if err != nil {
-		errorHandler(w, r,
-			"Failed to parse JSON response from remote SPARQL endpoint.",
-			http.StatusInternalServerError)
+	if len(d.Outgoing) == 0 && len(d.Incoming) == 0 {
+		if win.first() {
+			errorHandler(w, r, "This URI has no information", http.StatusNotFound)
+		} else {
+			errorHandler(w, r, "No more information on this URI", http.StatusNotFound)
+		}
 		return
 	}
 
-	if len(res.Results.Bindings) == 0 {
-		errorHandler(w, r, "This URI has no information", http.StatusNotFound)
-		return
+	var maxS, maxO int
+	if d.partial() {
+		// Fetch solution counts, if we hit the results limits
+		maxS, maxO, _ = countSolutions(r.Context(), uri, d.restriction)
 	}
 
-	var maxS, maxO int
-	if len(res.Results.Bindings) >= conf.QuadStore.ResultsLimit {
-		// Fetch solution counts, if we hit the results limit
-		q, _ := qBank.Prepare("count", struct{ URI string }{uri})
-		resp, err := repo.Query(conf.QuadStore.Endpoint, q, "json")
-		if err == nil {
-			res, err := sparql.ParseJSON(resp)
-			if err == nil {
-				b := res.Bindings()
-				v, _ := b["maxS"][0].(rdf.Literal).Typed()
-				maxS = v.(int)
-				v, _ = b["maxO"][0].(rdf.Literal).Typed()
-				maxO = v.(int)
-			}
-			resp.Close()
-		}
+	// Resources with more triples than fits on a page are summarized by
+	// graph and predicate on the first page
+	var summaryS, summaryO []predicateCount
+	if d.first() && d.Direction == "" {
+		summaryS, summaryO = summarizeHub(r, d)
+	}
 
+	var next, prev string
+	if d.hasNext() {
+		next = win.next().url(r)
+	}
+	if !win.first() {
+		prev = win.prev().url(r)
+	}
+
+	langs := requestLanguages(r)
+	pres := &presentation{
+		Labels:     resolveLabels(r.Context(), pageIRIs(d), langs),
+		Languages:  langs,
+		BlankNodes: d.BlankNodes,
+	}
+	subj := rejectWhereEmpty("o", d.Outgoing, pres)
+	obj := rejectWhereEmpty("s", d.Incoming, pres)
+
+	var cachedAtString string
+	if !d.CachedAt.IsZero() {
+		cachedAtString = d.CachedAt.Format("2 Jan 2006 15:04 MST")
+	}
+
+	// The subject table is grouped by predicate, unless the flat quad table
+	// is requested
+	view := r.FormValue("view")
+	if view != "table" {
+		view = ""
+	}
+
+	// The other representations of a blank node are served under its page
+	dataURL := uri
+	if strings.HasPrefix(r.URL.Path, genidPath) {
+		dataURL = strings.TrimSuffix(r.URL.Path, ".html")
 	}
 
-	solutions := res.Solutions()
-	subj := rejectWhereEmpty("o", solutions)
-	obj := rejectWhereEmpty("s", solutions)
+	var collapsed int
+	for _, el := range subj {
+		if el["class"] == "other-lang" {
+			collapsed++
+		}
+	}
 	data := struct {
 		Title               string
 		License, LicenseURL string
@@ -201,8 +276,22 @@ func (m mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		MaxSubject          int
 		MaxObject           int
 		Images              []string
+		Next, Prev          string
+		SummarySubject      []predicateCount
+		SummaryObject       []predicateCount
+		Restriction         restriction
+		ShowAll             string
+		Languages           string
+		Collapsed           int
+		View                string
+		Grouped             []propertyGroup
+		GroupedURL          string
+		TableURL            string
+		CachedAt            string
+		Truncated           bool
+		DataURL             string
 	}{
-		findTitle(conf.UI.TitlePredicates, solutions),
+		findTitle(conf.UI.TitlePredicates, langs, d.Outgoing),
 		conf.License,
 		conf.LicenseURL,
 		conf.QuadStore.Endpoint,
@@ -215,19 +304,41 @@ func (m mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		len(obj) - 1,
 		maxS,
 		maxO,
-		findImages(conf.UI.ImagePredicates, solutions),
+		findImages(conf.UI.ImagePredicates, d.Outgoing),
+		next,
+		prev,
+		summaryS,
+		summaryO,
+		d.restriction,
+		restriction{}.url(r),
+		strings.Join(langs, ","),
+		collapsed,
+		view,
+		groupByPredicate(subj),
+		viewURL(r, ""),
+		viewURL(r, "table"),
+		cachedAtString,
+		d.Truncated,
+		dataURL,
 	}
 
+	setLinkHeaders(w, r, win, d.hasNext())
+	w.Header().Add("Vary", "Accept-Language")
+	maxAge := setStaleHeaders(w, d.CachedAt, conf.Cache.HTMLMaxAge)
+	if checkConditional(w, r, dataETag(data), lastModified(d.Outgoing), maxAge) {
+		return
+	}
+
+	// The page is rendered before it is sent, so a failure can be answered
+	// with an error page
 	buf := bufpool.Get()
 	defer bufpool.Put(buf)
-	err = templates.ExecuteTemplate(buf, "index.html", data)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := templates.ExecuteTemplate(buf, "index.html", data); err != nil {
+		log.Printf("%s: %v", r.URL.Path, err)
+		errorHandler(w, r, "The page could not be rendered.", http.StatusInternalServerError)
 		return
 	}
-
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
 	buf.WriteTo(w)
 }
 
@@ -273,27 +384,34 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 func literalsHandler(w http.ResponseWriter, r *http.Request) {
 	uri := r.FormValue("uri")
 	q, _ := qBank.Prepare("literals", struct{ URI string }{uri})
-	resp, err := repo.Query(conf.QuadStore.Endpoint, q, "json")
+	resp, err := repo.Query(r.Context(), "literals", q, "results")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), upstreamErrorStatus(w, err))
 		return
 	}
-	res, err := sparql.ParseJSON(resp)
+	defer resp.Close()
+	solutions, err := parseSolutions(resp)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		err = malformedError(err)
+		http.Error(w, err.Error(), upstreamErrorStatus(w, err))
 		return
 	}
-	defer resp.Close()
-	if len(res.Results.Bindings) == 0 {
+	if len(solutions) == 0 {
 		w.Write([]byte("No literals on resource"))
 		return
 	}
 
+	// Only show the literals in the most preferred language of each predicate
+	collapsed := collapsedLanguages(requestLanguages(r), solutions)
+
 	var b bytes.Buffer
 	b.WriteString("<table class='preview'>")
-	for _, s := range res.Solutions() {
+	for i, s := range solutions {
+		if collapsed[i] {
+			continue
+		}
 		b.WriteString("<tr><td>" + prefixify(&conf.Vocab.Dict, s["p"].Serialize(rdf.Turtle)) + "</td><td>")
-		b.WriteString(s["o"].Serialize(rdf.Turtle) + "</td></tr>")
+		b.WriteString(template.HTMLEscapeString(s["o"].Serialize(rdf.Turtle)) + "</td></tr>")
 	}
 	b.WriteString("</table>")
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -309,15 +427,133 @@ func main() {
 		log.Fatal("Couldn't parse config file: ", err)
 	}
 
+	// Outgoing and incoming triples are limited by ResultsLimit, unless
+	// given their own limits
+	if conf.QuadStore.OutgoingLimit == 0 {
+		conf.QuadStore.OutgoingLimit = conf.QuadStore.ResultsLimit
+	}
+	if conf.QuadStore.IncomingLimit == 0 {
+		conf.QuadStore.IncomingLimit = conf.QuadStore.ResultsLimit
+	}
+
+	// Setup label cache
+	if conf.UI.LabelCacheSize == 0 {
+		conf.UI.LabelCacheSize = 10000
+	}
+	if conf.UI.LabelCacheTTL == 0 {
+		conf.UI.LabelCacheTTL = 3600
+	}
+	labelCache = newLRUCache(conf.UI.LabelCacheSize)
+
+	// The SPARQL dialect of the endpoints, standard SPARQL 1.1 if unknown
+	profile := lookupDialect(conf.QuadStore.Dialect)
+	if conf.QuadStore.BlankNodeIRI == "" {
+		conf.QuadStore.BlankNodeIRI = profile.BlankNodeIRI
+	}
+	if err := checkBlankNodeIRI(conf.QuadStore.BlankNodeIRI); err != nil {
+		log.Fatal("Invalid BlankNodeIRI: ", err)
+	}
+
+	// Credentials and TLS configuration of the endpoints
+	creds, err := newCredentials(conf.QuadStore.Auth, conf.QuadStore.Username,
+		conf.QuadStore.Password, conf.QuadStore.Token)
+	if err != nil {
+		log.Fatal("Couldn't read SPARQL endpoint credentials: ", err)
+	}
+	tlsConfig, err := newTLSConfig(conf.QuadStore.ClientCert, conf.QuadStore.ClientKey,
+		conf.QuadStore.CACert)
+	if err != nil {
+		log.Fatal("Couldn't load SPARQL endpoint certificates: ", err)
+	}
+
 	// Setup remote repository
+	if len(conf.QuadStore.Endpoints) == 0 {
+		conf.QuadStore.Endpoints = []string{conf.QuadStore.Endpoint}
+	}
+	if conf.QuadStore.Endpoint == "" {
+		conf.QuadStore.Endpoint = conf.QuadStore.Endpoints[0]
+	}
+	if conf.QuadStore.BreakerThreshold == 0 {
+		conf.QuadStore.BreakerThreshold = 5
+	}
+	if conf.QuadStore.BreakerCooldown == 0 {
+		conf.QuadStore.BreakerCooldown = 30
+	}
+	if conf.QuadStore.MaxIdleConns == 0 {
+		conf.QuadStore.MaxIdleConns = 16
+	}
+	if conf.QuadStore.IdleTimeout == 0 {
+		conf.QuadStore.IdleTimeout = 90
+	}
+	if conf.QuadStore.RetryBackoff == 0 {
+		conf.QuadStore.RetryBackoff = 100
+	}
 	repo = newRepo(
-		conf.QuadStore.Endpoint,
-		time.Duration(conf.QuadStore.OpenTimeout)*time.Millisecond,
-		time.Duration(conf.QuadStore.ReadTimeout)*time.Millisecond,
+		newEndpointPool(conf.QuadStore.Endpoints, conf.QuadStore.BreakerThreshold,
+			time.Duration(conf.QuadStore.BreakerCooldown)*time.Second),
+		clientOptions{
+			OpenTimeout:        time.Duration(conf.QuadStore.OpenTimeout) * time.Millisecond,
+			ReadTimeout:        time.Duration(conf.QuadStore.ReadTimeout) * time.Millisecond,
+			MaxIdleConns:       conf.QuadStore.MaxIdleConns,
+			IdleTimeout:        time.Duration(conf.QuadStore.IdleTimeout) * time.Second,
+			DisableCompression: conf.QuadStore.DisableCompression,
+			DisableHTTP2:       conf.QuadStore.DisableHTTP2,
+			TLS:                tlsConfig,
+			Credentials:        creds,
+		},
 	)
 
-	// Parse Query bank
-	qBank = sparql.LoadBank(bytes.NewBufferString(queries))
+	if conf.QuadStore.EndpointSelection == selectRoundRobin {
+		repo.endpoints.selection = selectRoundRobin
+	}
+	repo.protocol = conf.QuadStore.Protocol
+	repo.dialect = profile
+	repo.timeoutHint = conf.QuadStore.TimeoutHint
+	repo.resultsAccept = resultsAccept(conf.QuadStore.ResultsFormats)
+	repo.defaultGraphs = conf.QuadStore.DefaultGraphs
+	repo.namedGraphs = conf.QuadStore.NamedGraphs
+	repo.maxResponseSize = int64(conf.QuadStore.MaxResponseSize) << 20
+	repo.endpoints.hedgeAfter = time.Duration(conf.QuadStore.HedgeAfter) * time.Millisecond
+	repo.endpoints.retries = conf.QuadStore.Retries
+	repo.endpoints.retryBackoff = time.Duration(conf.QuadStore.RetryBackoff) * time.Millisecond
+
+	// Setup limiter of queries in flight
+	if conf.QuadStore.MaxConcurrent > 0 {
+		if conf.QuadStore.QueueTimeout == 0 {
+			conf.QuadStore.QueueTimeout = 2000
+		}
+		repo.limiter = newLimiter(conf.QuadStore.MaxConcurrent, conf.QuadStore.QueueSize,
+			time.Duration(conf.QuadStore.QueueTimeout)*time.Millisecond)
+	}
+
+	// Setup query results cache
+	if conf.Cache.Size > 0 {
+		repo.cache = newQueryCache(conf.Cache.Size, conf.Cache.TTL, conf.Cache.TTLs)
+	}
+
+	// Setup stale store, serving the last good responses when the SPARQL
+	// endpoint fails
+	if conf.Cache.StaleDir != "" {
+		if conf.Cache.StaleMaxSize == 0 {
+			conf.Cache.StaleMaxSize = 1024
+		}
+		if conf.Cache.StaleMaxAge == 0 {
+			conf.Cache.StaleMaxAge = 604800
+		}
+		repo.stale, err = newStaleStore(conf.Cache.StaleDir,
+			int64(conf.Cache.StaleMaxSize)<<20,
+			time.Duration(conf.Cache.StaleMaxAge)*time.Second)
+		if err != nil {
+			log.Fatal("Couldn't open stale store: ", err)
+		}
+	}
+
+	if conf.Admin.WarmConcurrency == 0 {
+		conf.Admin.WarmConcurrency = 4
+	}
+
+	// Parse Query bank, with the queries of the SPARQL dialect
+	qBank = profile.bank(queries)
 
 	// Register metrics
 	status = registerMetrics()
@@ -329,13 +565,20 @@ func main() {
 	mux.HandleFunc("/css/styles.css", serveFile("data/css/styles.css"))
 	mux.HandleFunc("/favicon.ico", serveFile("data/favicon.ico"))
 	mux.HandleFunc("/.status", statusHandler)
+	mux.HandleFunc(contextPath, contextHandler)
 	mux.HandleFunc("/literals", literalsHandler)
+	mux.HandleFunc("/api/v1/resource", apiResourceHandler)
+	mux.HandleFunc("/.admin/purge", adminHandler(purgeHandler))
+	mux.HandleFunc("/.admin/warm", adminHandler(warmHandler))
+	mux.Handle(genidPath, Timed(CountedByStatusXX(http.HandlerFunc(genidHandler), "status", metrics.DefaultRegistry),
+		"responseTime",
+		metrics.DefaultRegistry))
 	mux.Handle("/", Timed(CountedByStatusXX(handler, "status", metrics.DefaultRegistry),
 		"responseTime",
 		metrics.DefaultRegistry))
 
 	fmt.Printf("Listening on port %d ...\n", conf.ServePort)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", conf.ServePort), handlers.CompressHandler(mux))
+	err = http.ListenAndServe(fmt.Sprintf(":%d", conf.ServePort), handlers.CompressHandler(mux))
 	if err != nil {
 		log.Println(err)
 	}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/knakk/rdf"
+)
+
+// contextPath is where the shared JSON-LD context document is served.
+const contextPath = "/.context.jsonld"
+
+// jsonLDContext returns the JSON-LD context mapping the prefixes of the
+// vocabulary dictionary to their namespaces.
+func jsonLDContext() map[string]interface{} {
+	ctx := make(map[string]interface{})
+	for _, prefixPair := range vocabPrefixes() {
+		ctx[prefixPair[0]] = prefixPair[1]
+	}
+	return ctx
+}
+
+// contextHandler serves the JSON-LD context document referenced by the
+// JSON-LD representations, to clients of any origin.
+func contextHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/ld+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=86400, public")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{"@context": jsonLDContext()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// compactIRI returns the compact form of an IRI, if one of the prefixes in the
+// context applies.
+func compactIRI(prefixes [][]string, iri string) string {
+	if q, ok := qname(prefixes, iri); ok {
+		return q
+	}
+	return iri
+}
+
+// jsonLDNode returns the JSON-LD node reference of an IRI or blank node.
+func jsonLDNode(prefixes [][]string, t rdf.Term) string {
+	if t.Type() == rdf.TermBlank {
+		return t.Serialize(rdf.NTriples)
+	}
+	return compactIRI(prefixes, t.String())
+}
+
+// jsonLDValue returns the JSON-LD value of an RDF object.
+func jsonLDValue(prefixes [][]string, t rdf.Term) interface{} {
+	l, ok := t.(rdf.Literal)
+	if !ok {
+		return map[string]string{"@id": jsonLDNode(prefixes, t)}
+	}
+	if l.Lang() != "" {
+		return map[string]string{"@value": l.String(), "@language": l.Lang()}
+	}
+	if dt := l.DataType.String(); dt != "" && dt != nsXSD+"string" {
+		return map[string]string{"@value": l.String(), "@type": compactIRI(prefixes, dt)}
+	}
+	return l.String()
+}
+
+// jsonLDNodes groups the triples by subject into JSON-LD node objects.
+func jsonLDNodes(prefixes [][]string, quads []rdf.Quad) []map[string]interface{} {
+	var nodes []map[string]interface{}
+	var node map[string]interface{}
+	var subj string
+	for _, t := range triples(quads) {
+		if s := t.Subj.Serialize(rdf.NTriples); s != subj {
+			node = map[string]interface{}{"@id": jsonLDNode(prefixes, t.Subj)}
+			nodes = append(nodes, node)
+			subj = s
+		}
+		key := compactIRI(prefixes, t.Pred.String())
+		var v interface{}
+		if t.Pred.String() == nsRDF+"type" && t.Obj.Type() != rdf.TermLiteral {
+			key = "@type"
+			v = jsonLDNode(prefixes, t.Obj)
+		} else {
+			v = jsonLDValue(prefixes, t.Obj)
+		}
+		switch existing := node[key].(type) {
+		case nil:
+			node[key] = v
+		case []interface{}:
+			node[key] = append(existing, v)
+		default:
+			node[key] = []interface{}{existing, v}
+		}
+	}
+	return nodes
+}
+
+// encodeJSONLD serializes the quads as compacted JSON-LD, referencing the
+// shared context document. Each named graph becomes a node with its triples
+// in a @graph container.
+// http://www.w3.org/TR/json-ld/
+func encodeJSONLD(w io.Writer, quads []rdf.Quad) error {
+	prefixes := vocabPrefixes()
+
+	var graphs []string
+	byGraph := make(map[string][]rdf.Quad)
+	for _, q := range quads {
+		g := graphName(q)
+		if _, ok := byGraph[g]; !ok {
+			graphs = append(graphs, g)
+		}
+		byGraph[g] = append(byGraph[g], q)
+	}
+
+	top := []interface{}{}
+	for _, g := range graphs {
+		nodes := jsonLDNodes(prefixes, byGraph[g])
+		if g == "" {
+			for _, n := range nodes {
+				top = append(top, n)
+			}
+			continue
+		}
+		top = append(top, map[string]interface{}{
+			"@id":    jsonLDNode(prefixes, byGraph[g][0].Ctx),
+			"@graph": nodes,
+		})
+	}
+
+	doc := map[string]interface{}{
+		"@context": conf.BaseURI + contextPath,
+		"@graph":   top,
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+// labelBatchSize is the maximum number of IRIs resolved by one labels query,
+// keeping the query within the URL length limits of SPARQL endpoints.
+const labelBatchSize = 100
+
+// labelCache holds the candidate labels of IRIs, keyed by IRI. IRIs without
+// any label are cached with no candidates, so they are not looked up again
+// until expired.
+var labelCache *lruCache
+
+// labelCandidate is a literal of one of the title predicates of an IRI.
+type labelCandidate struct {
+	Label string
+	Lang  string
+	// Rank is the position of the predicate among the title predicates.
+	Rank int
+}
+
+// bestLabel returns the label in the most preferred language, using the order
+// of the title predicates to break ties.
+func bestLabel(langs []string, candidates []labelCandidate) (string, bool) {
+	best := -1
+	for i, c := range candidates {
+		if best == -1 {
+			best = i
+			continue
+		}
+		li, lb := languageRank(langs, c.Lang), languageRank(langs, candidates[best].Lang)
+		if li < lb || (li == lb && c.Rank < candidates[best].Rank) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return "", false
+	}
+	return candidates[best].Label, true
+}
+
+// labelsQuery holds the parameters of the labels query.
+type labelsQuery struct {
+	URIs       []string
+	Predicates []string
+}
+
+// pageIRIs returns the distinct IRIs linked to or from the resource in a
+// description; the objects of the outgoing and subjects of the incoming
+// triples, and the objects of its blank nodes.
+func pageIRIs(d *description) []string {
+	seen := make(map[string]bool)
+	var iris []string
+	add := func(t rdf.Term) {
+		if t == nil || t.Type() != rdf.TermIRI || seen[t.String()] || !validIRI(t.String()) {
+			return
+		}
+		seen[t.String()] = true
+		iris = append(iris, t.String())
+	}
+	for _, m := range d.Outgoing {
+		add(m["o"])
+	}
+	for _, m := range d.Incoming {
+		add(m["s"])
+	}
+	for _, solutions := range d.BlankNodes {
+		for _, m := range solutions {
+			add(m["o"])
+		}
+	}
+	return iris
+}
+
+// queryLabels looks up the candidate labels of the IRIs in one query.
+func queryLabels(ctx context.Context, iris []string) (map[string][]labelCandidate, error) {
+	solutions, err := querySolutions(ctx, "labels", labelsQuery{iris, conf.UI.TitlePredicates})
+	if err != nil {
+		return nil, err
+	}
+
+	rank := make(map[string]int)
+	for i, p := range conf.UI.TitlePredicates {
+		rank[p] = i
+	}
+	candidates := make(map[string][]labelCandidate)
+	for _, m := range solutions {
+		if m["s"] == nil || m["p"] == nil || m["o"] == nil {
+			continue
+		}
+		s := m["s"].String()
+		candidates[s] = append(candidates[s], labelCandidate{
+			Label: m["o"].String(),
+			Lang:  literalLanguage(m["o"]),
+			Rank:  rank[m["p"].String()],
+		})
+	}
+	return candidates, nil
+}
+
+// resolveLabels returns the labels of the IRIs in the preferred languages,
+// keyed by IRI. IRIs not in the label cache are looked up in batches, which
+// are queried concurrently.
+func resolveLabels(ctx context.Context, iris []string, langs []string) map[string]string {
+	labels := make(map[string]string)
+	if !conf.UI.ResolveLabels || len(conf.UI.TitlePredicates) == 0 {
+		return labels
+	}
+
+	var missing []string
+	for _, iri := range iris {
+		if c, ok := labelCache.Get(iri); ok {
+			if l, ok := bestLabel(langs, c.([]labelCandidate)); ok {
+				labels[iri] = l
+			}
+			continue
+		}
+		missing = append(missing, iri)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ttl := time.Duration(conf.UI.LabelCacheTTL) * time.Second
+	for i := 0; i < len(missing); i += labelBatchSize {
+		j := i + labelBatchSize
+		if j > len(missing) {
+			j = len(missing)
+		}
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			found, err := queryLabels(ctx, batch)
+			if err != nil {
+				// Leave the batch unlabeled, and uncached
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, iri := range batch {
+				labelCache.Set(iri, found[iri], ttl)
+				if l, ok := bestLabel(langs, found[iri]); ok {
+					labels[iri] = l
+				}
+			}
+		}(missing[i:j])
+	}
+	wg.Wait()
+	return labels
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// parseAcceptLanguage parses the value of an Accept-Language header into
+// language tags, ordered by preference. Wildcards and tags with q=0 are left
+// out.
+func parseAcceptLanguage(header string) []string {
+	type langQ struct {
+		tag string
+		q   float64
+	}
+	var tags []langQ
+	for _, el := range strings.Split(header, ",") {
+		params := strings.Split(el, ";")
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, langQ{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	var langs []string
+	for _, t := range tags {
+		langs = append(langs, t.tag)
+	}
+	return langs
+}
+
+// requestLanguages returns the preferred languages of the request, most
+// preferred first: the languages of the lang parameter, followed by the ones
+// of the Accept-Language header, followed by the configured languages.
+func requestLanguages(r *http.Request) []string {
+	var langs []string
+	seen := make(map[string]bool)
+	add := func(tags []string) {
+		for _, tag := range tags {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				langs = append(langs, tag)
+			}
+		}
+	}
+	if lang := r.FormValue("lang"); lang != "" {
+		add(strings.Split(lang, ","))
+	}
+	add(parseAcceptLanguage(r.Header.Get("Accept-Language")))
+	add(conf.UI.Languages)
+	return langs
+}
+
+// languageRank returns the position of the language tag among the preferred
+// languages; a tag matches a preferred language if equal to it, or if it is a
+// subtag of it. Literals without a language tag rank after the preferred
+// languages, and literals in other languages last.
+func languageRank(langs []string, tag string) int {
+	if tag == "" {
+		return len(langs)
+	}
+	tag = strings.ToLower(tag)
+	for i, l := range langs {
+		if tag == l || strings.HasPrefix(tag, l+"-") {
+			return i
+		}
+	}
+	return len(langs) + 1
+}
+
+// literalLanguage returns the language tag of a term, or an empty string if
+// it is not a literal with a language tag.
+func literalLanguage(t rdf.Term) string {
+	if l, ok := t.(rdf.Literal); ok {
+		return l.Lang()
+	}
+	return ""
+}
+
+// collapsedLanguages returns the indexes of the solutions where the object is
+// a literal with a language tag, ranked below another literal of the same
+// predicate.
+func collapsedLanguages(langs []string, solutions []map[string]rdf.Term) map[int]bool {
+	best := make(map[string]int)
+	for _, m := range solutions {
+		if m["o"] == nil || m["o"].Type() != rdf.TermLiteral {
+			continue
+		}
+		p, rank := m["p"].String(), languageRank(langs, literalLanguage(m["o"]))
+		if r, ok := best[p]; !ok || rank < r {
+			best[p] = rank
+		}
+	}
+	collapsed := make(map[int]bool)
+	for i, m := range solutions {
+		lang := literalLanguage(m["o"])
+		if lang == "" {
+			continue
+		}
+		if languageRank(langs, lang) > best[m["p"].String()] {
+			collapsed[i] = true
+		}
+	}
+	return collapsed
+}
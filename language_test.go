@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", nil},
+		{"nb", []string{"nb"}},
+		{"en-US,en;q=0.8,nb;q=0.9", []string{"en-us", "nb", "en"}},
+		{"*, de;q=0.5, fr;q=0", []string{"de"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAcceptLanguage(%q) => %v; want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLanguagePreference(t *testing.T) {
+	title, _ := rdf.NewIRI("http://purl.org/dc/terms/title")
+	lit := func(s, lang string) rdf.Term {
+		if lang == "" {
+			l, _ := rdf.NewLiteral(s)
+			return l
+		}
+		l, _ := rdf.NewLangLiteral(s, lang)
+		return l
+	}
+	solutions := []map[string]rdf.Term{
+		{"p": title, "o": lit("Hunger", "en")},
+		{"p": title, "o": lit("Sult", "nb")},
+		{"p": title, "o": lit("Svolt", "")},
+	}
+	langs := []string{"nb", "en"}
+
+	if got := findTitle([]string{title.String()}, langs, solutions); got != "Sult" {
+		t.Errorf("findTitle => %q; want %q", got, "Sult")
+	}
+	if got := findTitle([]string{title.String()}, []string{"de"}, solutions); got != "Svolt" {
+		t.Errorf("findTitle without matching language => %q; want %q", got, "Svolt")
+	}
+
+	want := map[int]bool{0: true}
+	if got := collapsedLanguages(langs, solutions); !reflect.DeepEqual(got, want) {
+		t.Errorf("collapsedLanguages => %v; want %v", got, want)
+	}
+}
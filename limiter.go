@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Priorities of the queries waiting for the limiter.
+const (
+	// priorityBulk is the priority of queries for data fetches, tooltips,
+	// and anything else not marked otherwise.
+	priorityBulk = iota
+	// priorityInteractive is the priority of queries for HTML pages.
+	priorityInteractive
+	numPriorities
+)
+
+// priorityKey is the context key of the priority of a query.
+type priorityKey struct{}
+
+// withPriority returns a context giving the queries run with it the priority.
+func withPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// queryPriority returns the priority of the queries run with the context.
+func queryPriority(ctx context.Context) int {
+	if p, ok := ctx.Value(priorityKey{}).(int); ok {
+		return p
+	}
+	return priorityBulk
+}
+
+// overloadedError is the error of a query not sent, because too many queries
+// are already waiting for the SPARQL endpoint.
+type overloadedError struct {
+	RetryAfter time.Duration
+}
+
+func (e overloadedError) Error() string {
+	return "too many queries waiting for the SPARQL endpoint"
+}
+
+// waiter is a query waiting for the limiter. It is sent nil when given a
+// slot, or an error when shed from the queue.
+type waiter struct {
+	ready chan error
+}
+
+// limiter caps the number of queries in flight to the SPARQL endpoint.
+// Queries over the cap wait in a queue, bounded in length and waiting time,
+// and are let through by priority. When the queue is full, a query of higher
+// priority than the newest waiting sheds it from the queue.
+type limiter struct {
+	slots        int
+	queueSize    int
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	queues   [numPriorities][]*waiter
+
+	active   metrics.Gauge
+	queued   metrics.Gauge
+	rejected metrics.Counter
+	timedOut metrics.Counter
+}
+
+// newLimiter returns a limiter letting slots queries through at a time, with
+// at most queueSize queries waiting for at most queueTimeout.
+func newLimiter(slots int, queueSize int, queueTimeout time.Duration) *limiter {
+	return &limiter{
+		slots:        slots,
+		queueSize:    queueSize,
+		queueTimeout: queueTimeout,
+		active:       metrics.GetOrRegisterGauge("limiter.active", metrics.DefaultRegistry),
+		queued:       metrics.GetOrRegisterGauge("limiter.queued", metrics.DefaultRegistry),
+		rejected:     metrics.GetOrRegisterCounter("limiter.rejected", metrics.DefaultRegistry),
+		timedOut:     metrics.GetOrRegisterCounter("limiter.timedOut", metrics.DefaultRegistry),
+	}
+}
+
+// waiting returns the number of queued queries. The caller must hold l.mu.
+func (l *limiter) waiting() int {
+	n := 0
+	for _, q := range l.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// update updates the gauges. The caller must hold l.mu.
+func (l *limiter) update() {
+	l.active.Update(int64(l.inFlight))
+	l.queued.Update(int64(l.waiting()))
+}
+
+// overloaded returns the error of a rejected query.
+func (l *limiter) overloaded() error {
+	l.rejected.Inc(1)
+	return overloadedError{l.queueTimeout}
+}
+
+// shed removes the newest waiting query of lower priority than the given one
+// from the queue, failing it. It returns false if there is none. The caller
+// must hold l.mu.
+func (l *limiter) shed(priority int) bool {
+	for p := 0; p < priority; p++ {
+		if n := len(l.queues[p]); n > 0 {
+			w := l.queues[p][n-1]
+			l.queues[p] = l.queues[p][:n-1]
+			w.ready <- l.overloaded()
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the waiter from the queue, and returns false if it is no
+// longer there. The caller must hold l.mu.
+func (l *limiter) remove(w *waiter) bool {
+	for p, q := range l.queues {
+		for i := range q {
+			if q[i] == w {
+				l.queues[p] = append(q[:i], q[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Acquire waits for a slot for a query with the priority of the context. It
+// fails with an overloadedError if the queue is full, or the query has waited
+// too long; or with the error of the context if it is canceled first. Each
+// successful Acquire must be followed by a Release.
+func (l *limiter) Acquire(ctx context.Context) error {
+	priority := queryPriority(ctx)
+	l.mu.Lock()
+	if l.inFlight < l.slots && l.waiting() == 0 {
+		l.inFlight++
+		l.update()
+		l.mu.Unlock()
+		return nil
+	}
+	if l.waiting() >= l.queueSize && !l.shed(priority) {
+		l.mu.Unlock()
+		return l.overloaded()
+	}
+	w := &waiter{make(chan error, 1)}
+	l.queues[priority] = append(l.queues[priority], w)
+	l.update()
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-w.ready:
+		return err
+	case <-timer.C:
+		l.timedOut.Inc(1)
+		err = overloadedError{l.queueTimeout}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	removed := l.remove(w)
+	l.update()
+	l.mu.Unlock()
+	if !removed {
+		// Given a slot, or shed, in the meantime
+		return <-w.ready
+	}
+	return err
+}
+
+// Release returns a slot, giving it to the first waiting query of the highest
+// priority.
+func (l *limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p := numPriorities - 1; p >= 0; p-- {
+		if len(l.queues[p]) > 0 {
+			w := l.queues[p][0]
+			l.queues[p] = l.queues[p][1:]
+			w.ready <- nil
+			l.update()
+			return
+		}
+	}
+	l.inFlight--
+	l.update()
+}
+
+// releaseBody is a response body, releasing its slot in the limiter when
+// closed.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
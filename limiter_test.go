@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1, 2, time.Second)
+	bulk := context.Background()
+	interactive := withPriority(bulk, priorityInteractive)
+
+	if err := l.Acquire(bulk); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue a bulk and an interactive query behind the one in flight
+	order := make(chan string, 2)
+	wait := func(ctx context.Context, name string) {
+		if err := l.Acquire(ctx); err != nil {
+			order <- err.Error()
+			return
+		}
+		order <- name
+		l.Release()
+	}
+	go wait(bulk, "bulk")
+	time.Sleep(10 * time.Millisecond)
+	go wait(interactive, "interactive")
+	time.Sleep(10 * time.Millisecond)
+
+	// The queue is full; bulk queries are rejected
+	if _, ok := l.Acquire(bulk).(overloadedError); !ok {
+		t.Errorf("expected query to be rejected with a full queue")
+	}
+
+	l.Release()
+	if first, second := <-order, <-order; first != "interactive" || second != "bulk" {
+		t.Errorf("expected interactive query to be let through first, got %s, %s", first, second)
+	}
+}
+
+func TestLimiterShedding(t *testing.T) {
+	l := newLimiter(1, 1, time.Second)
+	bulk := context.Background()
+	l.Acquire(bulk)
+
+	shed := make(chan error)
+	go func() { shed <- l.Acquire(bulk) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// An interactive query takes the place of the waiting bulk query
+	got := make(chan error)
+	go func() { got <- l.Acquire(withPriority(bulk, priorityInteractive)) }()
+	if _, ok := (<-shed).(overloadedError); !ok {
+		t.Errorf("expected bulk query to be shed from the queue")
+	}
+	l.Release()
+	if err := <-got; err != nil {
+		t.Errorf("expected interactive query to be let through, got %v", err)
+	}
+}
+
+func TestLimiterTimeout(t *testing.T) {
+	l := newLimiter(1, 10, 10*time.Millisecond)
+	l.Acquire(context.Background())
+
+	if _, ok := l.Acquire(context.Background()).(overloadedError); !ok {
+		t.Errorf("expected query to time out in the queue")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Acquire(ctx); err != context.Canceled {
+		t.Errorf("expected canceled query to leave the queue, got %v", err)
+	}
+
+	l.Release()
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Errorf("expected the slot to be free, got %v", err)
+	}
+}
@@ -15,9 +15,11 @@ type appMetrics struct {
 }
 
 type exportMetrics struct {
-	UpTime  string
-	PID     int
-	Metrics metrics.Registry
+	UpTime    string
+	PID       int
+	Metrics   metrics.Registry
+	Endpoints []endpointStatus
+	Warmup    *warmStatus
 }
 
 func registerMetrics() *appMetrics {
@@ -34,9 +36,11 @@ func (m *appMetrics) Export() *exportMetrics {
 	uptime := now.Sub(m.StartTime)
 
 	return &exportMetrics{
-		UpTime:  uptime.String(),
-		PID:     m.PID,
-		Metrics: metrics.DefaultRegistry,
+		UpTime:    uptime.String(),
+		PID:       m.PID,
+		Metrics:   metrics.DefaultRegistry,
+		Endpoints: repo.endpoints.Status(),
+		Warmup:    warmup.Status(),
 	}
 }
 
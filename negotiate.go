@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// representation is an available output format of a resource description.
+// The first of the media types is the one served as Content-Type.
+type representation struct {
+	Suffix     string
+	MediaTypes []string
+}
+
+// representations lists the output formats of a resource, in order of server
+// preference. The first one is chosen when the client expresses no preference.
+var representations = resourceRepresentations()
+
+func resourceRepresentations() []representation {
+	reps := []representation{
+		{".html", []string{"text/html", "application/xhtml+xml"}},
+		{".json", []string{"application/json"}},
+	}
+	for _, f := range rdfFormats {
+		reps = append(reps, f.representation)
+	}
+	return reps
+}
+
+// mediaRange is a parsed element of an HTTP Accept header.
+type mediaRange struct {
+	Type    string
+	Subtype string
+	Q       float64
+}
+
+// parseAccept parses the value of an Accept header into media ranges.
+// Malformed elements are skipped, and a missing q-value defaults to 1.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, el := range strings.Split(header, ",") {
+		params := strings.Split(el, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		i := strings.Index(mt, "/")
+		if i <= 0 || i == len(mt)-1 {
+			continue
+		}
+		mr := mediaRange{Type: mt[:i], Subtype: mt[i+1:], Q: 1}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			mr.Q = q
+		}
+		ranges = append(ranges, mr)
+	}
+	return ranges
+}
+
+// quality returns the q-value given to a media type by the most specific of
+// the matching media ranges, or 0 if none matches.
+func quality(ranges []mediaRange, mediaType string) float64 {
+	i := strings.Index(mediaType, "/")
+	typ, sub := mediaType[:i], mediaType[i+1:]
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		var s int
+		switch {
+		case mr.Type == typ && mr.Subtype == sub:
+			s = 2
+		case mr.Type == typ && mr.Subtype == "*":
+			s = 1
+		case mr.Type == "*" && mr.Subtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = mr.Q, s
+		}
+	}
+	return q
+}
+
+// negotiate returns the representation best matching the given Accept
+// header. Ties are resolved by the order of the representations slice. The
+// boolean is false if the client accepts none of them.
+func negotiate(header string, reps []representation) (representation, bool) {
+	if strings.TrimSpace(header) == "" {
+		return reps[0], true
+	}
+	ranges := parseAccept(header)
+	best, bestQ := -1, 0.0
+	for i, rep := range reps {
+		for _, mt := range rep.MediaTypes {
+			if q := quality(ranges, mt); q > bestQ {
+				best, bestQ = i, q
+			}
+		}
+	}
+	if best == -1 {
+		return representation{}, false
+	}
+	return reps[best], true
+}
+
+// availableFormats returns a human readable list of the representations.
+func availableFormats(reps []representation) string {
+	var formats []string
+	for _, rep := range reps {
+		formats = append(formats, rep.MediaTypes[0]+" ("+rep.Suffix+")")
+	}
+	return strings.Join(formats, "\n")
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	var negTests = []struct {
+		accept string
+		suffix string
+		ok     bool
+	}{
+		{"", ".html", true},
+		{"*/*", ".html", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", ".html", true},
+		{"application/json", ".json", true},
+		{"application/trig, text/html;q=0.5", ".trig", true},
+		{"text/turtle, application/rdf+xml", ".ttl", true},
+		{"application/rdf+xml;q=0.9, text/turtle;q=0.5", ".rdf", true},
+		{"text/*;q=0.3, application/x-trig;q=0.2", ".html", true},
+		{"text/html;q=0.1, application/json", ".json", true},
+		{"image/png", "", false},
+		{"*/*;q=0", "", false},
+	}
+
+	for i, tt := range negTests {
+		rep, ok := negotiate(tt.accept, representations)
+		if ok != tt.ok || rep.Suffix != tt.suffix {
+			t.Errorf("%d) negotiate(%q) => %q, %v; expected %q, %v",
+				i, tt.accept, rep.Suffix, ok, tt.suffix, tt.ok)
+		}
+	}
+}
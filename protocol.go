@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// How queries are sent to the SPARQL endpoint.
+const (
+	// protocolForm sends the query in a form-encoded POST body, as per the
+	// SPARQL 1.1 Protocol.
+	protocolForm = "form"
+	// protocolDirect sends the query unencoded as the POST body, with the
+	// media type application/sparql-query.
+	protocolDirect = "direct"
+	// protocolVirtuoso sends the query in the URL of a POST request, with
+	// the result format in the format parameter understood by Virtuoso.
+	protocolVirtuoso = "virtuoso"
+)
+
+// accept returns the Accept header of queries with results in the format;
+// N-Quads for "nquads", or else the configured SPARQL results formats.
+func (r *remoteRepo) accept(format string) string {
+	if format == "nquads" {
+		return "application/n-quads"
+	}
+	if r.resultsAccept == "" {
+		return resultsFormats["json"]
+	}
+	return r.resultsAccept
+}
+
+// queryParams returns the request parameters of the queries to the endpoints;
+// the RDF dataset of the default and named graphs, if given, and the timeout
+// hint of the dialect, if enabled.
+func (r *remoteRepo) queryParams() url.Values {
+	params := url.Values{}
+	if r.timeoutHint {
+		params = r.dialect.timeoutParams(r.timeout)
+	}
+	for _, g := range r.defaultGraphs {
+		params.Add("default-graph-uri", g)
+	}
+	for _, g := range r.namedGraphs {
+		params.Add("named-graph-uri", g)
+	}
+	return params
+}
+
+// newQueryRequest returns the request sending the query to the endpoint by
+// the protocol, asking for results in the media types of the Accept header,
+// with the other request parameters.
+func newQueryRequest(endpoint string, protocol string, query string, accept string, params url.Values) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	switch protocol {
+	case protocolVirtuoso:
+		params.Set("query", query)
+		// The format parameter takes one media type; the preferred one
+		format := strings.SplitN(accept, ",", 2)[0]
+		params.Set("format", strings.TrimSpace(strings.SplitN(format, ";", 2)[0]))
+		req, err = http.NewRequest("POST", withParams(endpoint, params), nil)
+	case protocolDirect:
+		req, err = http.NewRequest("POST", withParams(endpoint, params), strings.NewReader(query))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/sparql-query")
+		}
+	case protocolForm, "":
+		params.Set("query", query)
+		req, err = http.NewRequest("POST", endpoint, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	default:
+		return nil, fmt.Errorf("unknown SPARQL protocol: %q", protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	return req, nil
+}
+
+// withParams returns the URL with the parameters added to its query string.
+func withParams(u string, params url.Values) string {
+	if len(params) == 0 {
+		return u
+	}
+	if strings.Contains(u, "?") {
+		return u + "&" + params.Encode()
+	}
+	return u + "?" + params.Encode()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestNewQueryRequest(t *testing.T) {
+	const query = "SELECT * WHERE { ?s ?p ?o }"
+	graphs := []string{"http://example.org/g1", "http://example.org/g2"}
+
+	tests := []struct {
+		protocol    string
+		contentType string
+		// params are the parameters in the URL, body those in the body
+		params url.Values
+		body   string
+	}{
+		{protocolForm, "application/x-www-form-urlencoded", url.Values{},
+			url.Values{"query": {query}, "default-graph-uri": graphs, "named-graph-uri": graphs[:1]}.Encode()},
+		{protocolDirect, "application/sparql-query",
+			url.Values{"default-graph-uri": graphs, "named-graph-uri": graphs[:1]}, query},
+		{protocolVirtuoso, "",
+			url.Values{"query": {query}, "format": {"application/n-quads"}, "default-graph-uri": graphs, "named-graph-uri": graphs[:1]}, ""},
+	}
+	for _, tt := range tests {
+		params := url.Values{"default-graph-uri": graphs, "named-graph-uri": graphs[:1]}
+		req, err := newQueryRequest("http://example.org/sparql", tt.protocol, query, "application/n-quads", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Method != "POST" {
+			t.Errorf("%s: expected POST, got %s", tt.protocol, req.Method)
+		}
+		if got := req.Header.Get("Accept"); got != "application/n-quads" {
+			t.Errorf("%s: Accept => %q; want application/n-quads", tt.protocol, got)
+		}
+		if got := req.Header.Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type => %q; want %q", tt.protocol, got, tt.contentType)
+		}
+		if got := req.URL.Query(); !reflect.DeepEqual(got, tt.params) {
+			t.Errorf("%s: URL parameters => %v; want %v", tt.protocol, got, tt.params)
+		}
+		var body []byte
+		if req.Body != nil {
+			body, _ = ioutil.ReadAll(req.Body)
+		}
+		if string(body) != tt.body {
+			t.Errorf("%s: body => %q; want %q", tt.protocol, body, tt.body)
+		}
+	}
+
+	if _, err := newQueryRequest("http://example.org/sparql", "soap", query, "application/sparql-results+json", url.Values{}); err == nil {
+		t.Errorf("expected unknown protocol to fail")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// queryCall is a query to the SPARQL endpoint in flight, or completed.
+type queryCall struct {
+	done chan struct{}
+	body []byte
+	err  error
+}
+
+// queryCache caches the response bodies of SPARQL queries. Concurrent
+// identical queries missing the cache are coalesced, sharing the response of
+// one request to the endpoint.
+type queryCache struct {
+	results *lruCache
+	ttls    map[string]time.Duration
+	ttl     time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]*queryCall
+
+	hits      metrics.Counter
+	misses    metrics.Counter
+	coalesced metrics.Counter
+}
+
+// newQueryCache returns a queryCache holding at most size query results,
+// for ttl seconds; or for the number of seconds in ttls, keyed by query tag.
+func newQueryCache(size int, ttl int, ttls map[string]int) *queryCache {
+	c := &queryCache{
+		results:   newLRUCache(size),
+		ttls:      make(map[string]time.Duration),
+		ttl:       time.Duration(ttl) * time.Second,
+		inFlight:  make(map[string]*queryCall),
+		hits:      metrics.GetOrRegisterCounter("queryCache.hits", metrics.DefaultRegistry),
+		misses:    metrics.GetOrRegisterCounter("queryCache.misses", metrics.DefaultRegistry),
+		coalesced: metrics.GetOrRegisterCounter("queryCache.coalesced", metrics.DefaultRegistry),
+	}
+	for tag, secs := range ttls {
+		c.ttls[tag] = time.Duration(secs) * time.Second
+	}
+	return c
+}
+
+// tagTTL returns the time-to-live of the results of queries with the given
+// tag.
+func (c *queryCache) tagTTL(tag string) time.Duration {
+	if ttl, ok := c.ttls[tag]; ok {
+		return ttl
+	}
+	return c.ttl
+}
+
+// Do returns the cached result stored under key, or else calls fn and returns
+// the response body it streams. fn calls done with the body once it is read
+// whole, or with the error failing it, and the body is then cached for the
+// time-to-live of the tag; failed calls are not. Concurrent calls with the
+// same key wait for the body to be read, and share it. Waiting for a call in
+// flight is given up when the context is canceled.
+func (c *queryCache) Do(ctx context.Context, key string, tag string, fn func(done func([]byte, error)) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	for {
+		if body, ok := c.results.Get(key); ok {
+			c.hits.Inc(1)
+			return ioutil.NopCloser(bytes.NewReader(body.([]byte))), nil
+		}
+
+		c.mu.Lock()
+		call, ok := c.inFlight[key]
+		if !ok {
+			call = &queryCall{done: make(chan struct{})}
+			c.inFlight[key] = call
+			c.mu.Unlock()
+			return c.call(key, tag, call, fn)
+		}
+		c.mu.Unlock()
+
+		c.coalesced.Inc(1)
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		switch {
+		case call.err == nil:
+			return ioutil.NopCloser(bytes.NewReader(call.body)), nil
+		case tooLarge(call.err):
+			return &truncatedBody{bytes.NewReader(call.body), call.err}, nil
+		case (call.err == context.Canceled || call.err == errBodyClosed || call.err == errPartialResults) && ctx.Err() == nil:
+			// The call was canceled or given up by the client of another
+			// request, or its results were not shared; try again
+			continue
+		}
+		return nil, call.err
+	}
+}
+
+// call calls fn, and completes the call in flight when its body is read,
+// caching it.
+func (c *queryCache) call(key string, tag string, call *queryCall, fn func(done func([]byte, error)) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	c.misses.Inc(1)
+	body, err := fn(func(body []byte, err error) {
+		c.complete(key, tag, call, body, err)
+	})
+	if err != nil {
+		c.complete(key, tag, call, nil, err)
+		return nil, err
+	}
+	return body, nil
+}
+
+// complete caches the body of a call, unless it failed, and completes it.
+func (c *queryCache) complete(key string, tag string, call *queryCall, body []byte, err error) {
+	call.body, call.err = body, err
+	if err == nil {
+		c.results.Set(key, body, c.tagTTL(tag))
+	}
+
+	c.mu.Lock()
+	delete(c.inFlight, key)
+	c.mu.Unlock()
+	close(call.done)
+}
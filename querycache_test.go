@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// streamBody returns the response body of a call of queryCache.Do, streaming
+// the result.
+func streamBody(result string, done func([]byte, error)) io.ReadCloser {
+	return &teeBody{ReadCloser: ioutil.NopCloser(strings.NewReader(result)), done: done}
+}
+
+// readBody reads a response body returned by queryCache.Do.
+func readBody(body io.ReadCloser, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	return string(b), err
+}
+
+func TestQueryCache(t *testing.T) {
+	c := newQueryCache(10, 60, map[string]int{"literals": 0})
+
+	var calls int32
+	fn := func(done func([]byte, error)) (io.ReadCloser, error) {
+		atomic.AddInt32(&calls, 1)
+		return streamBody("result", done), nil
+	}
+	for i := 0; i < 2; i++ {
+		if b, err := readBody(c.Do(context.Background(), "q", "outgoing", fn)); err != nil || b != "result" {
+			t.Fatalf("Do => %q, %v; want %q, nil", b, err, "result")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected cached result to be reused, got %d calls", calls)
+	}
+
+	if c.tagTTL("literals") != 0 || c.tagTTL("count") != time.Minute {
+		t.Errorf("tagTTL => %v, %v; want 0, 1m", c.tagTTL("literals"), c.tagTTL("count"))
+	}
+
+	failing := func(done func([]byte, error)) (io.ReadCloser, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("timeout")
+	}
+	c.Do(context.Background(), "f", "outgoing", failing)
+	c.Do(context.Background(), "f", "outgoing", failing)
+	if calls != 3 {
+		t.Errorf("expected failed results not to be cached, got %d calls", calls)
+	}
+
+	// A body closed before its end is not cached
+	body, _ := c.Do(context.Background(), "p", "outgoing", fn)
+	body.Close()
+	if b, _ := readBody(c.Do(context.Background(), "p", "outgoing", fn)); b != "result" || calls != 5 {
+		t.Errorf("expected body closed early not to be cached, got %q after %d calls", b, calls)
+	}
+}
+
+func TestQueryCacheCoalescing(t *testing.T) {
+	c := newQueryCache(10, 60, nil)
+
+	var calls int32
+	release := make(chan bool)
+	fn := func(done func([]byte, error)) (io.ReadCloser, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return streamBody("result", done), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b, _ := readBody(c.Do(context.Background(), "q", "outgoing", fn)); b != "result" {
+				t.Errorf("Do => %q; want %q", b, "result")
+			}
+		}()
+	}
+	// Let the concurrent queries reach the cache before completing the first
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected concurrent queries to be coalesced, got %d calls", calls)
+	}
+}
+
+func TestQueryCacheCanceled(t *testing.T) {
+	c := newQueryCache(10, 60, nil)
+
+	// The first request's client disconnects while others wait for its query
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan bool)
+	canceled := func(done func([]byte, error)) (io.ReadCloser, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	go c.Do(ctx, "q", "outgoing", canceled)
+	<-started
+
+	done := make(chan string)
+	go func() {
+		b, _ := readBody(c.Do(context.Background(), "q", "outgoing", func(done func([]byte, error)) (io.ReadCloser, error) {
+			return streamBody("result", done), nil
+		}))
+		done <- b
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if b := <-done; b != "result" {
+		t.Errorf("expected waiting query to be run again, got %q", b)
+	}
+
+	// A waiting request gives up when its own client disconnects
+	block := make(chan bool)
+	defer close(block)
+	go c.Do(context.Background(), "slow", "outgoing", func(done func([]byte, error)) (io.ReadCloser, error) {
+		<-block
+		return nil, errors.New("timeout")
+	})
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(ctx, "slow", "outgoing", nil); err != context.DeadlineExceeded {
+		t.Errorf("expected waiting to end with the context, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// queryErrorKind is the category of a failed query.
+type queryErrorKind int
+
+const (
+	// errConnect is a failure to connect to the endpoint, or a connection
+	// lost before the response was read.
+	errConnect queryErrorKind = iota
+	// errTimeout is a request not answered within the timeout.
+	errTimeout
+	// errQuery is a query answered by the endpoint with an error status.
+	errQuery
+	// errMalformed is a response which could not be parsed.
+	errMalformed
+	// errTooLarge is a response exceeding the size limit.
+	errTooLarge
+)
+
+// queryErrorNames are the names of the categories, as used in the metrics.
+var queryErrorNames = [...]string{"connect", "timeout", "query", "malformed", "tooLarge"}
+
+func (k queryErrorKind) String() string {
+	return queryErrorNames[k]
+}
+
+// queryError is the error of a failed query, classified by its cause.
+type queryError struct {
+	Kind queryErrorKind
+	// StatusCode is the HTTP status of the endpoint's response, for errQuery
+	StatusCode int
+	// Message is the error message of the endpoint, or the underlying error
+	Message string
+}
+
+// newQueryError returns a queryError, counting it in the metrics of its
+// category.
+func newQueryError(kind queryErrorKind, statusCode int, message string) queryError {
+	metrics.GetOrRegisterCounter("upstream.errors."+kind.String(), metrics.DefaultRegistry).Inc(1)
+	return queryError{Kind: kind, StatusCode: statusCode, Message: message}
+}
+
+func (e queryError) Error() string {
+	switch e.Kind {
+	case errConnect:
+		return "could not connect to the SPARQL endpoint: " + e.Message
+	case errTimeout:
+		return "the SPARQL endpoint did not answer in time"
+	case errQuery:
+		if e.Message == "" {
+			return fmt.Sprintf("the SPARQL endpoint failed to answer the query (HTTP status %d)", e.StatusCode)
+		}
+		return fmt.Sprintf("the SPARQL endpoint failed to answer the query (HTTP status %d): %s", e.StatusCode, e.Message)
+	case errMalformed:
+		return "malformed response from the SPARQL endpoint: " + e.Message
+	case errTooLarge:
+		return "the response from the SPARQL endpoint is too large"
+	}
+	return e.Message
+}
+
+// tooLarge returns true if err is the error of a response exceeding the size
+// limit.
+func tooLarge(err error) bool {
+	e, ok := err.(queryError)
+	return ok && e.Kind == errTooLarge
+}
+
+// transportError classifies the error of sending a request, or reading its
+// response, with the given context. The error of the context is returned as
+// it is if it was canceled.
+func transportError(ctx context.Context, err error) error {
+	if ctx.Err() == context.Canceled {
+		return context.Canceled
+	}
+	if _, ok := err.(queryError); ok {
+		return err
+	}
+	// The URL is in the request log already
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var ne net.Error
+	if ctx.Err() == context.DeadlineExceeded || errors.As(err, &ne) && ne.Timeout() {
+		return newQueryError(errTimeout, 0, err.Error())
+	}
+	return newQueryError(errConnect, 0, err.Error())
+}
+
+// malformedError classifies the error of parsing a response from the
+// endpoint; unless it was reading the response which failed.
+func malformedError(err error) error {
+	var qe queryError
+	if errors.As(err, &qe) {
+		return qe
+	}
+	if err == context.Canceled {
+		return err
+	}
+	return newQueryError(errMalformed, 0, err.Error())
+}
+
+// upstreamBody is a response body from an endpoint, classifying the errors
+// of reading it.
+type upstreamBody struct {
+	io.ReadCloser
+	ctx context.Context
+}
+
+func (b upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = transportError(b.ctx, err)
+	}
+	return n, err
+}
+
+const (
+	// maxErrorBody is the number of bytes read of an error response.
+	maxErrorBody = 8192
+	// maxErrorMessage is the number of characters kept of an error message.
+	maxErrorMessage = 300
+)
+
+var (
+	htmlHeadRg = regexp.MustCompile(`(?is)<(head|script|style)\b.*?</(head|script|style)>`)
+	htmlTagRg  = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// endpointMessage returns the error message in the body of an error response
+// from the endpoint. Only the first paragraph of the message is kept, on one
+// line; the rest is usually the query or a stack trace.
+func endpointMessage(resp *http.Response) string {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	s := string(b)
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var doc map[string]interface{}
+		if json.Unmarshal(b, &doc) == nil {
+			for _, k := range []string{"message", "error", "detail"} {
+				if v, ok := doc[k].(string); ok && v != "" {
+					s = v
+					break
+				}
+			}
+		}
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		s = htmlHeadRg.ReplaceAllString(s, "")
+		s = html.UnescapeString(htmlTagRg.ReplaceAllString(s, " "))
+		s = strings.Join(strings.Fields(s), " ")
+	}
+
+	s = strings.TrimSpace(strings.Replace(s, "\r\n", "\n", -1))
+	if i := strings.Index(s, "\n\n"); i != -1 {
+		s = s[:i]
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxErrorMessage {
+		s = string(r[:maxErrorMessage]) + "…"
+	}
+	return s
+}
+
+// upstreamErrorStatus returns the HTTP status of a response to a request
+// failing because of the query err, setting the Retry-After header if the
+// endpoints are unavailable or overloaded.
+func upstreamErrorStatus(w http.ResponseWriter, err error) int {
+	var retryAfter time.Duration
+	switch e := err.(type) {
+	case circuitOpenError:
+		retryAfter = e.RetryAfter
+	case overloadedError:
+		retryAfter = e.RetryAfter
+	case queryError:
+		switch e.Kind {
+		case errTimeout:
+			return http.StatusGatewayTimeout
+		case errQuery:
+			// The endpoint rejecting the query is an error of Fenster
+			if e.StatusCode < 500 {
+				return http.StatusInternalServerError
+			}
+			return http.StatusBadGateway
+		case errConnect, errMalformed:
+			return http.StatusBadGateway
+		}
+		return http.StatusInternalServerError
+	default:
+		return http.StatusInternalServerError
+	}
+	secs := int(retryAfter/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	return http.StatusServiceUnavailable
+}
+
+// upstreamErrorMessage returns the message of the error page of a request
+// failing because of the query err. The cause of a connection error names the
+// endpoint, and is left to the log.
+func upstreamErrorMessage(err error) string {
+	e, ok := err.(queryError)
+	if !ok {
+		return err.Error() + ". Refresh to try again."
+	}
+	switch e.Kind {
+	case errConnect:
+		return "The SPARQL endpoint could not be reached. Refresh to try again."
+	case errTimeout:
+		return "The SPARQL endpoint did not answer in time. Refresh to try again.\n\n" +
+			"You can increase the timeout values in Fensters configuration file."
+	case errQuery:
+		msg := fmt.Sprintf("The SPARQL endpoint failed to answer the query (HTTP status %d).", e.StatusCode)
+		if e.Message != "" {
+			msg += "\n\n" + e.Message
+		}
+		return msg
+	case errMalformed:
+		return "The response from the SPARQL endpoint could not be understood.\n\n" + e.Message
+	case errTooLarge:
+		return "The description of this resource is too large to be shown."
+	}
+	return e.Error()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestQueryErrors(t *testing.T) {
+	responses := map[string]struct {
+		status      int
+		contentType string
+		body        string
+	}{
+		"/virtuoso": {400, "text/plain", "Virtuoso 37000 Error SP030: SPARQL compiler, line 1: syntax error\r\n\r\nSPARQL query:\r\nASK {"},
+		"/jetty":    {500, "text/html", "<html><head><title>Error 500</title></head><body><h2>HTTP ERROR 500</h2>\n<p>Problem accessing /sparql. Reason:\n<pre>    Server &amp; Error</pre></p>\n\n<hr/></body></html>"},
+		"/json":     {503, "application/json", `{"code": "QE0", "message": "Store is shutting down"}`},
+		"/slow":     {200, "", ""},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responses[r.URL.Path]
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", resp.contentType)
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+	}))
+	defer ts.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		url     string
+		kind    queryErrorKind
+		message string
+		status  int
+	}{
+		{ts.URL + "/virtuoso", errQuery, "Virtuoso 37000 Error SP030: SPARQL compiler, line 1: syntax error", 500},
+		{ts.URL + "/jetty", errQuery, "HTTP ERROR 500 Problem accessing /sparql. Reason: Server & Error", 502},
+		{ts.URL + "/json", errQuery, "Store is shutting down", 502},
+		{ts.URL + "/slow", errTimeout, "", 504},
+		{closed.URL, errConnect, "", 502},
+	}
+	for _, tt := range tests {
+		r := newRepo(newEndpointPool([]string{tt.url}, 5, time.Second),
+			clientOptions{OpenTimeout: time.Second, ReadTimeout: 50 * time.Millisecond})
+		before := metrics.GetOrRegisterCounter("upstream.errors."+tt.kind.String(), metrics.DefaultRegistry).Count()
+		_, err := r.Query(context.Background(), "errtest", "ASK {}", "results")
+		r.Close()
+		e, ok := err.(queryError)
+		if !ok || e.Kind != tt.kind {
+			t.Errorf("%s: expected %s error, got %#v", tt.url, tt.kind, err)
+			continue
+		}
+		if tt.message != "" && e.Message != tt.message {
+			t.Errorf("%s: expected message %q, got %q", tt.url, tt.message, e.Message)
+		}
+		if strings.Contains(e.Message, tt.url) {
+			t.Errorf("%s: expected message without the URL, got %q", tt.url, e.Message)
+		}
+		if got := upstreamErrorStatus(httptest.NewRecorder(), err); got != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.url, tt.status, got)
+		}
+		if n := metrics.GetOrRegisterCounter("upstream.errors."+tt.kind.String(), metrics.DefaultRegistry).Count(); n != before+1 {
+			t.Errorf("%s: expected the %s error to be counted once, got %d", tt.url, tt.kind, n-before)
+		}
+	}
+}
+
+func TestMalformedError(t *testing.T) {
+	_, err := parseSolutions(strings.NewReader(`{"head": `))
+	if e, ok := malformedError(err).(queryError); !ok || e.Kind != errMalformed {
+		t.Errorf("expected malformed error, got %#v", malformedError(err))
+	}
+	timeout := queryError{Kind: errTimeout}
+	if got := malformedError(timeout); got != timeout {
+		t.Errorf("expected error reading the response to be kept, got %#v", got)
+	}
+	if got := transportError(context.Background(), errors.New("connection reset")); got.(queryError).Kind != errConnect {
+		t.Errorf("expected connect error, got %#v", got)
+	}
+}
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	if got := upstreamErrorStatus(w, circuitOpenError{1500 * time.Millisecond}); got != 503 {
+		t.Errorf("expected 503 with open circuits, got %d", got)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("expected Retry-After: 2, got %q", got)
+	}
+	if got := upstreamErrorStatus(httptest.NewRecorder(), overloadedError{time.Second}); got != 503 {
+		t.Errorf("expected 503 when overloaded, got %d", got)
+	}
+	if got := upstreamErrorStatus(httptest.NewRecorder(), queryError{Kind: errMalformed}); got != 502 {
+		t.Errorf("expected 502 with malformed responses, got %d", got)
+	}
+	if got := upstreamErrorStatus(httptest.NewRecorder(), queryError{Kind: errTooLarge}); got != 500 {
+		t.Errorf("expected 500 with too large responses, got %d", got)
+	}
+	if got := upstreamErrorStatus(httptest.NewRecorder(), errors.New("other")); got != 500 {
+		t.Errorf("expected 500 with other errors, got %d", got)
+	}
+}
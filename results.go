@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+// resultsFormats are the media types of the SPARQL results formats, by name.
+var resultsFormats = map[string]string{
+	"json": "application/sparql-results+json",
+	"xml":  "application/sparql-results+xml",
+	"tsv":  "text/tab-separated-values",
+}
+
+// resultsAccept returns the Accept header asking for the named results
+// formats, in order of preference. Unknown formats are skipped, and JSON is
+// asked for if none are known. CSV is skipped too, since it does not tell
+// IRIs from literals, nor carry datatypes.
+func resultsAccept(formats []string) string {
+	var types []string
+	for _, f := range formats {
+		mt, ok := resultsFormats[strings.ToLower(f)]
+		if !ok {
+			if strings.EqualFold(f, "csv") {
+				log.Printf("SPARQL results in CSV lose the types of their terms; not asking for them")
+			}
+			continue
+		}
+		if n := len(types); n > 0 {
+			mt += fmt.Sprintf(";q=0.%d", 10-n)
+		}
+		types = append(types, mt)
+	}
+	if len(types) == 0 {
+		return resultsFormats["json"]
+	}
+	return strings.Join(types, ", ")
+}
+
+// solutionDecoder decodes the solutions of a SPARQL results document one by
+// one. Decode returns io.EOF after the last solution.
+type solutionDecoder interface {
+	Decode() (map[string]rdf.Term, error)
+}
+
+// newSolutionDecoder returns the decoder of a SPARQL results document in any
+// of the results formats, recognized by its first characters: JSON starts
+// with {, XML with <, and TSV with the ? of its first variable. Other
+// documents, such as CSV results, fail to decode.
+// Leading whitespace and byte order marks are skipped.
+// Since the response is identified by its content, it is parsed the same
+// when served from the caches.
+func newSolutionDecoder(r io.Reader) (solutionDecoder, error) {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+	var first byte
+	for n := 1; first == 0; n++ {
+		b, err := br.Peek(n)
+		if err == io.EOF {
+			return nil, errors.New("empty SPARQL results")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c := b[n-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			first = c
+			br.Discard(n - 1)
+		}
+	}
+
+	switch first {
+	case '{':
+		return &jsonSolutionDecoder{dec: json.NewDecoder(br)}, nil
+	case '<':
+		return &xmlSolutionDecoder{dec: xml.NewDecoder(br)}, nil
+	case '?':
+		return newTSVSolutionDecoder(br)
+	}
+	return nil, errors.New("unsupported SPARQL results format; expected JSON, XML or TSV")
+}
+
+// parseSolutions parses the solutions of a SPARQL results document; see
+// newSolutionDecoder. On error, the solutions decoded until then are
+// returned with it. The document is read to its end, so the response body
+// is complete, to be cached.
+func parseSolutions(r io.Reader) ([]map[string]rdf.Term, error) {
+	dec, err := newSolutionDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	var solutions []map[string]rdf.Term
+	for {
+		solution, err := dec.Decode()
+		if err == io.EOF {
+			_, err = io.Copy(ioutil.Discard, r)
+			return solutions, err
+		}
+		if err != nil {
+			return solutions, err
+		}
+		solutions = append(solutions, solution)
+	}
+}
+
+// jsonTerm is an RDF term in SPARQL 1.1 Query Results JSON.
+type jsonTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Lang     string `json:"xml:lang"`
+	Datatype string `json:"datatype"`
+}
+
+// jsonSolutionDecoder decodes SPARQL 1.1 Query Results JSON, one binding of
+// the results at a time.
+type jsonSolutionDecoder struct {
+	dec *json.Decoder
+	// inBindings is true when positioned in the bindings array
+	inBindings bool
+}
+
+func (d *jsonSolutionDecoder) Decode() (map[string]rdf.Term, error) {
+	if !d.inBindings {
+		if err := d.seekBindings(); err != nil {
+			return nil, err
+		}
+		d.inBindings = true
+	}
+	if !d.dec.More() {
+		return nil, io.EOF
+	}
+	var binding map[string]jsonTerm
+	if err := d.dec.Decode(&binding); err != nil {
+		return nil, err
+	}
+	solution := make(map[string]rdf.Term, len(binding))
+	for v, b := range binding {
+		var t rdf.Term
+		var err error
+		switch b.Type {
+		case "uri":
+			t, err = rdf.NewIRI(b.Value)
+		case "bnode":
+			t, err = rdf.NewBlank(b.Value)
+		case "literal", "typed-literal":
+			t, err = newLiteral(b.Value, b.Lang, b.Datatype)
+		default:
+			err = fmt.Errorf("unknown term type in binding of %s: %q", v, b.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		solution[v] = t
+	}
+	return solution, nil
+}
+
+// seekBindings advances the decoder into the bindings array of the results,
+// skipping the other members of the document. It returns io.EOF if there
+// are no results, as for ASK queries.
+func (d *jsonSolutionDecoder) seekBindings() error {
+	for _, key := range []string{"results", "bindings"} {
+		if err := d.expect(json.Delim('{')); err != nil {
+			return err
+		}
+		for {
+			if !d.dec.More() {
+				return io.EOF
+			}
+			tok, err := d.dec.Token()
+			if err != nil {
+				return err
+			}
+			if tok == key {
+				break
+			}
+			var skip json.RawMessage
+			if err := d.dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	return d.expect(json.Delim('['))
+}
+
+// expect reads the next token, failing if it is not the delimiter.
+func (d *jsonSolutionDecoder) expect(delim json.Delim) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("invalid SPARQL results: expected %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+// xmlResult is a result of a SPARQL Query Results XML document.
+type xmlResult struct {
+	Bindings []struct {
+		Name    string  `xml:"name,attr"`
+		URI     *string `xml:"uri"`
+		BNode   *string `xml:"bnode"`
+		Literal *struct {
+			Value    string `xml:",chardata"`
+			Lang     string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+			Datatype string `xml:"datatype,attr"`
+		} `xml:"literal"`
+	} `xml:"binding"`
+}
+
+// xmlSolutionDecoder decodes SPARQL Query Results XML, one result element at
+// a time.
+type xmlSolutionDecoder struct {
+	dec *xml.Decoder
+}
+
+func (d *xmlSolutionDecoder) Decode() (map[string]rdf.Term, error) {
+	for {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "result" {
+			continue
+		}
+		var result xmlResult
+		if err := d.dec.DecodeElement(&result, &start); err != nil {
+			return nil, err
+		}
+		solution := make(map[string]rdf.Term, len(result.Bindings))
+		for _, b := range result.Bindings {
+			var t rdf.Term
+			switch {
+			case b.URI != nil:
+				t, err = rdf.NewIRI(*b.URI)
+			case b.BNode != nil:
+				t, err = rdf.NewBlank(*b.BNode)
+			case b.Literal != nil:
+				t, err = newLiteral(b.Literal.Value, b.Literal.Lang, b.Literal.Datatype)
+			default:
+				err = fmt.Errorf("unknown term in binding of %s", b.Name)
+			}
+			if err != nil {
+				return nil, err
+			}
+			solution[b.Name] = t
+		}
+		return solution, nil
+	}
+}
+
+// newLiteral returns a literal with the language tag or datatype, if given.
+func newLiteral(value, lang, datatype string) (rdf.Term, error) {
+	if lang != "" {
+		return rdf.NewLangLiteral(value, lang)
+	}
+	if datatype != "" {
+		dt, err := rdf.NewIRI(datatype)
+		if err != nil {
+			return nil, err
+		}
+		return rdf.NewTypedLiteral(value, dt), nil
+	}
+	return rdf.NewLiteral(value)
+}
+
+// tsvUnescaper unescapes the string escapes of Turtle.
+var tsvUnescaper = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\r`, "\r", `\"`, `"`, `\'`, "'", `\\`, `\`)
+
+// tsvTerm parses an RDF term in a SPARQL TSV results document, where terms
+// are written as in Turtle. An empty field is an unbound variable.
+func tsvTerm(s string) (rdf.Term, error) {
+	switch {
+	case s == "":
+		return nil, nil
+	case strings.HasPrefix(s, "<") && strings.HasSuffix(s, ">"):
+		return rdf.NewIRI(s[1 : len(s)-1])
+	case strings.HasPrefix(s, "_:"):
+		return rdf.NewBlank(s[2:])
+	case strings.HasPrefix(s, `"`):
+		end := strings.LastIndex(s, `"`)
+		if end == 0 {
+			return nil, fmt.Errorf("unterminated literal: %s", s)
+		}
+		value, suffix := tsvUnescaper.Replace(s[1:end]), s[end+1:]
+		switch {
+		case strings.HasPrefix(suffix, "@"):
+			return newLiteral(value, suffix[1:], "")
+		case strings.HasPrefix(suffix, "^^<") && strings.HasSuffix(suffix, ">"):
+			return newLiteral(value, "", suffix[3:len(suffix)-1])
+		case suffix == "":
+			return newLiteral(value, "", "")
+		}
+		return nil, fmt.Errorf("invalid literal: %s", s)
+	case s == "true" || s == "false":
+		return newLiteral(s, "", nsXSD+"boolean")
+	case strings.ContainsAny(s, "eE"):
+		return newLiteral(s, "", nsXSD+"double")
+	case strings.Contains(s, "."):
+		return newLiteral(s, "", nsXSD+"decimal")
+	}
+	return newLiteral(s, "", nsXSD+"integer")
+}
+
+// tsvSolutionDecoder decodes SPARQL 1.1 Query Results TSV, one line at a
+// time.
+type tsvSolutionDecoder struct {
+	scanner *bufio.Scanner
+	vars    []string
+}
+
+func newTSVSolutionDecoder(r io.Reader) (*tsvSolutionDecoder, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	if !scanner.Scan() {
+		return nil, scanner.Err()
+	}
+	vars := strings.Split(strings.TrimRight(scanner.Text(), "\r"), "\t")
+	for i, v := range vars {
+		vars[i] = strings.TrimPrefix(v, "?")
+	}
+	return &tsvSolutionDecoder{scanner: scanner, vars: vars}, nil
+}
+
+func (d *tsvSolutionDecoder) Decode() (map[string]rdf.Term, error) {
+	if !d.scanner.Scan() {
+		if err := d.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	fields := strings.Split(strings.TrimRight(d.scanner.Text(), "\r"), "\t")
+	solution := make(map[string]rdf.Term)
+	for i, f := range fields {
+		if i >= len(d.vars) {
+			break
+		}
+		t, err := tsvTerm(f)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			solution[d.vars[i]] = t
+		}
+	}
+	return solution, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knakk/rdf"
+)
+
+const (
+	jsonResults = `{"head": {"vars": ["s", "o", "n", "b"]},
+ "results": {"bindings": [
+  {"s": {"type": "uri", "value": "http://example.org/r"},
+   "o": {"type": "literal", "value": "Ibsen \"H.\"", "xml:lang": "no"},
+   "n": {"type": "literal", "value": "3", "datatype": "http://www.w3.org/2001/XMLSchema#integer"}},
+  {"b": {"type": "bnode", "value": "b1"}}]}}`
+
+	xmlResultsDoc = `<?xml version="1.0"?>
+<sparql xmlns="http://www.w3.org/2005/sparql-results#">
+  <head><variable name="s"/><variable name="o"/><variable name="n"/><variable name="b"/></head>
+  <results>
+    <result>
+      <binding name="s"><uri>http://example.org/r</uri></binding>
+      <binding name="o"><literal xml:lang="no">Ibsen "H."</literal></binding>
+      <binding name="n"><literal datatype="http://www.w3.org/2001/XMLSchema#integer">3</literal></binding>
+    </result>
+    <result><binding name="b"><bnode>b1</bnode></binding></result>
+  </results>
+</sparql>`
+
+	tsvResults = "?s\t?o\t?n\t?b\n" +
+		"<http://example.org/r>\t\"Ibsen \\\"H.\\\"\"@no\t3\t\n" +
+		"\t\t\t_:b1\n"
+)
+
+// describeSolutions returns the solutions as terms serialized as in Turtle,
+// for comparison.
+func describeSolutions(solutions []map[string]rdf.Term) string {
+	var s []string
+	for _, m := range solutions {
+		for _, v := range []string{"s", "o", "n", "b"} {
+			if t, ok := m[v]; ok {
+				lang := ""
+				if l, ok := t.(rdf.Literal); ok && l.Lang() != "" {
+					lang = "@" + l.Lang()
+				}
+				s = append(s, v+"="+t.String()+lang)
+			}
+		}
+		s = append(s, "|")
+	}
+	return strings.Join(s, " ")
+}
+
+func TestParseSolutions(t *testing.T) {
+	want := `s=http://example.org/r o=Ibsen "H."@no n=3 | b=b1 |`
+	for name, doc := range map[string]string{"json": jsonResults, "xml": xmlResultsDoc, "tsv": tsvResults} {
+		solutions, err := parseSolutions(strings.NewReader("\xef\xbb\xbf  " + doc))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := describeSolutions(solutions); got != want {
+			t.Errorf("%s: parseSolutions => %s; want %s", name, got, want)
+		}
+		if n := solutions[0]["n"]; n == nil || intValue(n) != 3 {
+			t.Errorf("%s: expected integer literal, got %v", name, n)
+		}
+	}
+
+	if _, err := parseSolutions(strings.NewReader(" \n")); err == nil {
+		t.Errorf("expected empty results to fail")
+	}
+}
+
+// failAfter returns a reader of s, failing with err at its end.
+func failAfter(s string, err error) io.Reader {
+	return &truncatedBody{bytes.NewReader([]byte(s)), err}
+}
+
+func TestSolutionDecoder(t *testing.T) {
+	partial := map[string]string{
+		"json": jsonResults[:strings.Index(jsonResults, `{"b"`)],
+		"xml":  xmlResultsDoc[:strings.Index(xmlResultsDoc, "<result><binding")],
+		"tsv":  tsvResults[:strings.Index(tsvResults, "\t\t\t")],
+	}
+	for name, doc := range partial {
+		solutions, err := parseSolutions(failAfter(doc, queryError{Kind: errTooLarge}))
+		if !tooLarge(err) || len(solutions) != 1 {
+			t.Errorf("%s: expected the solution read before the error, got %d, %v", name, len(solutions), err)
+		}
+	}
+
+	// The bindings are found wherever they are in the document
+	solutions, err := parseSolutions(strings.NewReader(`{"results": {"bindings": [{"s": {"type": "uri", "value": "http://example.org/r"}}]}, "head": {"vars": ["s"]}}`))
+	if err != nil || len(solutions) != 1 {
+		t.Errorf("expected 1 solution, got %d, %v", len(solutions), err)
+	}
+	solutions, err = parseSolutions(strings.NewReader(`{"head": {}, "boolean": true}`))
+	if err != nil || len(solutions) != 0 {
+		t.Errorf("expected no solutions of ASK results, got %d, %v", len(solutions), err)
+	}
+}
+
+func TestParseCSVSolutions(t *testing.T) {
+	// CSV does not tell the IRI from the literal, nor the count from text
+	doc := "s,o,n\r\nurn:x,ISBN:123,3\r\n"
+	if _, err := parseSolutions(strings.NewReader(doc)); err == nil {
+		t.Errorf("expected CSV results to fail to decode")
+	}
+}
+
+func TestQueryResultsCSV(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("o,n\r\nISBN:123,3\r\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/sparql-results+json")
+		w.Write([]byte(`{"head": {"vars": ["o", "n"]}, "results": {"bindings": [
+			{"o": {"type": "literal", "value": "ISBN:123"},
+			 "n": {"type": "literal", "value": "3", "datatype": "http://www.w3.org/2001/XMLSchema#integer"}}]}}`))
+	}))
+	defer ts.Close()
+
+	r := newRepo(newEndpointPool([]string{ts.URL}, 5, time.Second),
+		clientOptions{OpenTimeout: time.Second, ReadTimeout: time.Second})
+	defer r.Close()
+	r.resultsAccept = resultsAccept([]string{"csv", "json"})
+
+	resp, err := r.Query(context.Background(), "count", "SELECT * {}", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Close()
+	solutions, err := parseSolutions(resp)
+	if err != nil || len(solutions) != 1 {
+		t.Fatalf("parseSolutions => %d, %v; want 1, nil", len(solutions), err)
+	}
+	if n := intValue(solutions[0]["n"]); n != 3 {
+		t.Errorf("expected the count to survive, got %d", n)
+	}
+	if o := solutions[0]["o"]; o.Type() != rdf.TermLiteral || o.String() != "ISBN:123" {
+		t.Errorf("expected the literal to survive, got %v", o)
+	}
+}
+
+func TestResultsAccept(t *testing.T) {
+	tests := []struct {
+		formats []string
+		want    string
+	}{
+		{nil, "application/sparql-results+json"},
+		{[]string{"xml", "bogus", "TSV"}, "application/sparql-results+xml, text/tab-separated-values;q=0.9"},
+		{[]string{"csv", "json"}, "application/sparql-results+json"},
+	}
+	for _, tt := range tests {
+		if got := resultsAccept(tt.formats); got != tt.want {
+			t.Errorf("resultsAccept(%v) => %q; want %q", tt.formats, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/knakk/rdf"
+)
+
+const (
+	nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXSD = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// rdfFormat is an RDF serialization format which resource descriptions can be
+// served in.
+type rdfFormat struct {
+	representation
+	encode func(w io.Writer, quads []rdf.Quad) error
+}
+
+// rdfFormats lists the supported RDF serialization formats, in order of
+// server preference.
+var rdfFormats = []rdfFormat{
+	{representation{".ttl", []string{"text/turtle", "application/x-turtle"}}, encodeTurtle},
+	{representation{".trig", []string{"application/trig", "application/x-trig"}}, encodeTriG},
+	{representation{".nt", []string{"application/n-triples"}}, encodeNTriples},
+	{representation{".nq", []string{"application/n-quads"}}, encodeNQuads},
+	{representation{".rdf", []string{"application/rdf+xml"}}, encodeRDFXML},
+	{representation{".jsonld", []string{"application/ld+json"}}, encodeJSONLD},
+}
+
+// findRDFFormat returns the RDF format served under the given path suffix.
+func findRDFFormat(suffix string) (rdfFormat, bool) {
+	for _, f := range rdfFormats {
+		if f.Suffix == suffix {
+			return f, true
+		}
+	}
+	return rdfFormat{}, false
+}
+
+// localNameRg matches local names which are valid both as a Turtle PN_LOCAL
+// and as a XML NCName.
+var localNameRg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_\-]*$`)
+
+// vocabPrefixes returns the prefix/namespace pairs to use when serializing.
+func vocabPrefixes() [][]string {
+	if !conf.Vocab.Enabled {
+		return nil
+	}
+	return conf.Vocab.Dict
+}
+
+// qname returns the prefixed name of an IRI, if it belongs to one of the
+// namespaces and the remaining local name is serializable as is.
+func qname(prefixes [][]string, iri string) (string, bool) {
+	for _, prefixPair := range prefixes {
+		if strings.HasPrefix(iri, prefixPair[1]) {
+			local := strings.TrimPrefix(iri, prefixPair[1])
+			if localNameRg.MatchString(local) {
+				return prefixPair[0] + ":" + local, true
+			}
+		}
+	}
+	return "", false
+}
+
+// triples returns the distinct triples of the quads, sorted by subject and
+// predicate, as the graph information is lost when merged.
+func triples(quads []rdf.Quad) []rdf.Triple {
+	seen := make(map[string]bool)
+	var ts []rdf.Triple
+	for _, q := range quads {
+		key := q.Subj.Serialize(rdf.NTriples) + q.Pred.Serialize(rdf.NTriples) + q.Obj.Serialize(rdf.NTriples)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ts = append(ts, q.Triple)
+	}
+	sort.SliceStable(ts, func(i, j int) bool {
+		si, sj := ts[i].Subj.Serialize(rdf.NTriples), ts[j].Subj.Serialize(rdf.NTriples)
+		if si != sj {
+			return si < sj
+		}
+		return ts[i].Pred.Serialize(rdf.NTriples) < ts[j].Pred.Serialize(rdf.NTriples)
+	})
+	return ts
+}
+
+func encodeTurtle(w io.Writer, quads []rdf.Quad) error {
+	enc := rdf.NewTripleEncoder(w, rdf.Turtle)
+	enc.Namespaces = make(map[string]string)
+	for _, prefixPair := range vocabPrefixes() {
+		enc.Namespaces[prefixPair[1]] = prefixPair[0]
+	}
+	if err := enc.EncodeAll(triples(quads)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func encodeNTriples(w io.Writer, quads []rdf.Quad) error {
+	enc := rdf.NewTripleEncoder(w, rdf.NTriples)
+	if err := enc.EncodeAll(triples(quads)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func encodeNQuads(w io.Writer, quads []rdf.Quad) error {
+	enc := rdf.NewQuadEncoder(w, rdf.NQuads)
+	if err := enc.EncodeAll(quads); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// turtleTerm serializes a term in Turtle syntax, using prefixed names
+// where possible.
+func turtleTerm(prefixes [][]string, t rdf.Term) string {
+	if t.Type() == rdf.TermIRI {
+		if t.String() == nsRDF+"type" {
+			return "a"
+		}
+		if q, ok := qname(prefixes, t.String()); ok {
+			return q
+		}
+	}
+	return t.Serialize(rdf.Turtle)
+}
+
+// graphName returns the serialized graph of a quad, or an empty string if it
+// belongs to the default graph.
+func graphName(q rdf.Quad) string {
+	if q.Ctx == nil {
+		return ""
+	}
+	return q.Ctx.Serialize(rdf.NTriples)
+}
+
+// encodeTriG serializes the quads in TriG syntax:
+// http://www.w3.org/TR/trig/
+func encodeTriG(w io.Writer, quads []rdf.Quad) error {
+	prefixes := vocabPrefixes()
+	bw := bufio.NewWriter(w)
+	for _, prefixPair := range prefixes {
+		fmt.Fprintf(bw, "@prefix %s: <%s> .\n", prefixPair[0], prefixPair[1])
+	}
+
+	sorted := make([]rdf.Quad, len(quads))
+	copy(sorted, quads)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return graphName(sorted[i]) < graphName(sorted[j])
+	})
+
+	for i := 0; i < len(sorted); {
+		g := graphName(sorted[i])
+		j := i
+		var graph []rdf.Quad
+		for ; j < len(sorted) && graphName(sorted[j]) == g; j++ {
+			graph = append(graph, sorted[j])
+		}
+		indent := ""
+		bw.WriteString("\n")
+		if g != "" {
+			fmt.Fprintf(bw, "%s {\n", turtleTerm(prefixes, sorted[i].Ctx))
+			indent = "\t"
+		}
+		var subj, pred string
+		for _, t := range triples(graph) {
+			s, p, o := turtleTerm(prefixes, t.Subj), turtleTerm(prefixes, t.Pred), turtleTerm(prefixes, t.Obj)
+			switch {
+			case s == subj && p == pred:
+				fmt.Fprintf(bw, " ,\n%s\t\t%s", indent, o)
+			case s == subj:
+				fmt.Fprintf(bw, " ;\n%s\t%s %s", indent, p, o)
+			default:
+				if subj != "" {
+					bw.WriteString(" .\n")
+				}
+				fmt.Fprintf(bw, "%s%s %s %s", indent, s, p, o)
+			}
+			subj, pred = s, p
+		}
+		if subj != "" {
+			bw.WriteString(" .\n")
+		}
+		if g != "" {
+			bw.WriteString("}\n")
+		}
+		i = j
+	}
+	return bw.Flush()
+}
+
+// splitIRI splits an IRI into a namespace and a local name which is a valid
+// XML NCName. The boolean is false if no such split exists.
+func splitIRI(iri string) (string, string, bool) {
+	i := strings.LastIndexAny(iri, "#/")
+	if i == -1 || !localNameRg.MatchString(iri[i+1:]) {
+		return "", "", false
+	}
+	return iri[:i+1], iri[i+1:], true
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// nodeAttr returns the attribute identifying a subject or object node.
+func nodeAttr(attr string, t rdf.Term) string {
+	if t.Type() == rdf.TermBlank {
+		return fmt.Sprintf(`rdf:nodeID="%s"`, xmlEscape(strings.TrimPrefix(t.Serialize(rdf.NTriples), "_:")))
+	}
+	return fmt.Sprintf(`rdf:%s="%s"`, attr, xmlEscape(t.String()))
+}
+
+// encodeRDFXML serializes the quads as RDF/XML, merging all graphs:
+// http://www.w3.org/TR/rdf-syntax-grammar/
+func encodeRDFXML(w io.Writer, quads []rdf.Quad) error {
+	ts := triples(quads)
+
+	// Collect the namespaces of all predicates, which must be declared on
+	// the root element.
+	prefixOf := map[string]string{nsRDF: "rdf"}
+	var namespaces []string
+	for _, t := range ts {
+		ns, _, ok := splitIRI(t.Pred.String())
+		if !ok {
+			return fmt.Errorf("cannot serialize predicate as RDF/XML: %s", t.Pred.String())
+		}
+		if _, ok := prefixOf[ns]; ok {
+			continue
+		}
+		prefix := fmt.Sprintf("ns%d", len(namespaces)+1)
+		for _, prefixPair := range vocabPrefixes() {
+			if prefixPair[1] == ns && prefixPair[0] != "rdf" {
+				prefix = prefixPair[0]
+				break
+			}
+		}
+		prefixOf[ns] = prefix
+		namespaces = append(namespaces, ns)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	fmt.Fprintf(bw, "<rdf:RDF xmlns:rdf=\"%s\"", nsRDF)
+	for _, ns := range namespaces {
+		fmt.Fprintf(bw, "\n\txmlns:%s=\"%s\"", prefixOf[ns], xmlEscape(ns))
+	}
+	bw.WriteString(">\n")
+
+	var subj string
+	for _, t := range ts {
+		if s := t.Subj.Serialize(rdf.NTriples); s != subj {
+			if subj != "" {
+				bw.WriteString("\t</rdf:Description>\n")
+			}
+			fmt.Fprintf(bw, "\t<rdf:Description %s>\n", nodeAttr("about", t.Subj))
+			subj = s
+		}
+		ns, local, _ := splitIRI(t.Pred.String())
+		el := prefixOf[ns] + ":" + local
+		switch o := t.Obj.(type) {
+		case rdf.Literal:
+			attr := ""
+			if o.Lang() != "" {
+				attr = fmt.Sprintf(` xml:lang="%s"`, xmlEscape(o.Lang()))
+			} else if dt := o.DataType.String(); dt != "" && dt != nsXSD+"string" {
+				attr = fmt.Sprintf(` rdf:datatype="%s"`, xmlEscape(dt))
+			}
+			fmt.Fprintf(bw, "\t\t<%s%s>%s</%s>\n", el, attr, xmlEscape(o.String()), el)
+		default:
+			fmt.Fprintf(bw, "\t\t<%s %s/>\n", el, nodeAttr("resource", o))
+		}
+	}
+	if subj != "" {
+		bw.WriteString("\t</rdf:Description>\n")
+	}
+	bw.WriteString("</rdf:RDF>\n")
+	return bw.Flush()
+}
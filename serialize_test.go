@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knakk/rdf"
+)
+
+func testQuads() []rdf.Quad {
+	g, _ := rdf.NewIRI("http://data.deichman.no/graph")
+	s, _ := rdf.NewIRI("http://data.deichman.no/resource/tnr_1")
+	title, _ := rdf.NewIRI("http://purl.org/dc/terms/title")
+	creator, _ := rdf.NewIRI("http://purl.org/dc/terms/creator")
+	lit, _ := rdf.NewLangLiteral("Sult", "no")
+	o, _ := rdf.NewIRI("http://data.deichman.no/person/x_1")
+	return []rdf.Quad{
+		{Triple: rdf.Triple{Subj: s, Pred: title, Obj: lit}, Ctx: g},
+		{Triple: rdf.Triple{Subj: s, Pred: creator, Obj: o}, Ctx: g},
+		{Triple: rdf.Triple{Subj: s, Pred: creator, Obj: o}, Ctx: g},
+	}
+}
+
+// withVocab enables the vocabulary dictionary for the test, restoring the
+// configuration after.
+func withVocab(t *testing.T) {
+	vocab, baseURI := conf.Vocab, conf.BaseURI
+	t.Cleanup(func() { conf.Vocab, conf.BaseURI = vocab, baseURI })
+	conf.Vocab.Enabled = true
+	conf.Vocab.Dict = [][]string{{"dc", "http://purl.org/dc/terms/"}}
+	conf.BaseURI = "http://data.deichman.no"
+}
+
+func TestEncodeTriG(t *testing.T) {
+	withVocab(t)
+
+	var b bytes.Buffer
+	if err := encodeTriG(&b, testQuads()); err != nil {
+		t.Fatal(err)
+	}
+	want := `@prefix dc: <http://purl.org/dc/terms/> .
+
+<http://data.deichman.no/graph> {
+	<http://data.deichman.no/resource/tnr_1> dc:creator <http://data.deichman.no/person/x_1> ;
+		dc:title "Sult"@no .
+}
+`
+	if b.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, b.String())
+	}
+}
+
+func TestEncodeRDFXML(t *testing.T) {
+	withVocab(t)
+
+	var b bytes.Buffer
+	if err := encodeRDFXML(&b, testQuads()); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="utf-8"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmlns:dc="http://purl.org/dc/terms/">
+	<rdf:Description rdf:about="http://data.deichman.no/resource/tnr_1">
+		<dc:creator rdf:resource="http://data.deichman.no/person/x_1"/>
+		<dc:title xml:lang="no">Sult</dc:title>
+	</rdf:Description>
+</rdf:RDF>
+`
+	if b.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, b.String())
+	}
+}
+
+func TestEncodeJSONLD(t *testing.T) {
+	withVocab(t)
+
+	var b bytes.Buffer
+	if err := encodeJSONLD(&b, testQuads()); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "@context": "http://data.deichman.no/.context.jsonld",
+  "@graph": [
+    {
+      "@graph": [
+        {
+          "@id": "http://data.deichman.no/resource/tnr_1",
+          "dc:creator": {
+            "@id": "http://data.deichman.no/person/x_1"
+          },
+          "dc:title": {
+            "@language": "no",
+            "@value": "Sult"
+          }
+        }
+      ],
+      "@id": "http://data.deichman.no/graph"
+    }
+  ]
+}
+`
+	if b.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, b.String())
+	}
+}
+
+func TestContextHandler(t *testing.T) {
+	withVocab(t)
+
+	w := httptest.NewRecorder()
+	contextHandler(w, httptest.NewRequest("GET", contextPath, nil))
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected the context to be served to any origin, got %v", w.Header())
+	}
+	if !strings.Contains(w.Body.String(), `"dc":"http://purl.org/dc/terms/"`) {
+		t.Errorf("expected the prefixes in the context, got %s", w.Body.String())
+	}
+}
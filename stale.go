@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// staleBody is the response body of a query served from the stale store,
+// because the SPARQL endpoint failed to answer it.
+type staleBody struct {
+	io.ReadCloser
+	CachedAt time.Time
+}
+
+// cachedAt returns the time a response body was stored, if served from the
+// stale store, or the zero time.
+func cachedAt(body io.ReadCloser) time.Time {
+	if s, ok := body.(*staleBody); ok {
+		return s.CachedAt
+	}
+	return time.Time{}
+}
+
+// setStaleHeaders sets the Warning header of a response served from the stale
+// store, if stored at the given time, and returns the max age of the response
+// in HTTP caches; 0 if stale.
+func setStaleHeaders(w http.ResponseWriter, storedAt time.Time, maxAge int) int {
+	if storedAt.IsZero() {
+		return maxAge
+	}
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	return 0
+}
+
+// staleEntry is a response body stored on disk, with the key of its query.
+type staleEntry struct {
+	key      string
+	size     int64
+	storedAt time.Time
+}
+
+// A file of the stale store holds the length of the key of the query, on a
+// line of its own, followed by the key and the response body.
+
+// readStaleKey reads the key at the start of a file of the stale store.
+func readStaleKey(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line, "\n"))
+	if err != nil || n < 0 {
+		return "", errors.New("invalid stale store file")
+	}
+	key := make([]byte, n)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// staleKey reads the key of the file of the stale store at path.
+func staleKey(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readStaleKey(bufio.NewReader(f))
+}
+
+// staleWrite is a response body queued to be written to the stale store.
+type staleWrite struct {
+	key  string
+	body []byte
+}
+
+// staleQueueSize is the number of response bodies queued to be written to
+// the stale store, before more are dropped.
+const staleQueueSize = 64
+
+// staleStore keeps the last successful response of each query on disk, to be
+// served in place of failed queries. The store is limited to maxSize bytes,
+// evicting the oldest responses first, and responses older than maxAge are
+// not served. Responses are written in the background; see Put.
+type staleStore struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]staleEntry
+
+	writes  chan staleWrite
+	pending sync.WaitGroup
+
+	served      metrics.Counter
+	unavailable metrics.Counter
+	dropped     metrics.Counter
+}
+
+// newStaleStore returns a staleStore keeping its responses in dir, indexing
+// the ones already there.
+func newStaleStore(dir string, maxSize int64, maxAge time.Duration) (*staleStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &staleStore{
+		dir:         dir,
+		maxSize:     maxSize,
+		maxAge:      maxAge,
+		entries:     make(map[string]staleEntry),
+		writes:      make(chan staleWrite, staleQueueSize),
+		served:      metrics.GetOrRegisterCounter("staleStore.served", metrics.DefaultRegistry),
+		unavailable: metrics.GetOrRegisterCounter("staleStore.unavailable", metrics.DefaultRegistry),
+		dropped:     metrics.GetOrRegisterCounter("staleStore.dropped", metrics.DefaultRegistry),
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		if filepath.Ext(fi.Name()) == ".tmp" {
+			// Left by an interrupted Set
+			os.Remove(path)
+			continue
+		}
+		key, err := staleKey(path)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		s.entries[fi.Name()] = staleEntry{key, fi.Size(), fi.ModTime()}
+		s.size += fi.Size()
+	}
+	s.mu.Lock()
+	s.evict()
+	s.mu.Unlock()
+	go s.writer()
+	return s, nil
+}
+
+// fileName returns the name of the file storing the response of a query.
+func (s *staleStore) fileName(key string) string {
+	h := sha1.Sum([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// Put queues the response body of the query with the given key to be stored
+// in the background, so that requests do not wait for the disk. The body is
+// dropped if the queue is full, and must not be modified after.
+func (s *staleStore) Put(key string, body []byte) {
+	if int64(len(body)) > s.maxSize {
+		return
+	}
+	s.pending.Add(1)
+	select {
+	case s.writes <- staleWrite{key, body}:
+	default:
+		s.pending.Done()
+		s.dropped.Inc(1)
+	}
+}
+
+// writer stores the queued response bodies.
+func (s *staleStore) writer() {
+	for w := range s.writes {
+		if err := s.Set(w.key, w.body); err != nil {
+			log.Printf("failed to store query response: %v", err)
+		}
+		s.pending.Done()
+	}
+}
+
+// flush waits until the queued response bodies are stored.
+func (s *staleStore) flush() {
+	s.pending.Wait()
+}
+
+// Set stores the response body of the query with the given key.
+func (s *staleStore) Set(key string, body []byte) error {
+	if int64(len(body)) > s.maxSize {
+		return nil
+	}
+	name := s.fileName(key)
+	tmp, err := ioutil.TempFile(s.dir, name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	header := fmt.Sprintf("%d\n%s", len(key), key)
+	_, err = io.WriteString(tmp, header)
+	if err == nil {
+		_, err = tmp.Write(body)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := int64(len(header) + len(body))
+	s.size += size - s.entries[name].size
+	s.entries[name] = staleEntry{key, size, time.Now()}
+	s.evict()
+	return nil
+}
+
+// Get returns the stored response body of the query with the given key, and
+// when it was stored, unless missing or too old.
+func (s *staleStore) Get(key string) ([]byte, time.Time, bool) {
+	name := s.fileName(key)
+	s.mu.Lock()
+	e, ok := s.entries[name]
+	s.mu.Unlock()
+	if !ok || time.Since(e.storedAt) > s.maxAge {
+		s.unavailable.Inc(1)
+		return nil, time.Time{}, false
+	}
+	body, err := s.read(name, key)
+	if err != nil {
+		s.unavailable.Inc(1)
+		return nil, time.Time{}, false
+	}
+	s.served.Inc(1)
+	return body, e.storedAt, true
+}
+
+// read returns the response body in the named file, stored with the key.
+func (s *staleStore) read(name string, key string) ([]byte, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if k, err := readStaleKey(r); err != nil || k != key {
+		return nil, errors.New("invalid stale store file")
+	}
+	return ioutil.ReadAll(r)
+}
+
+// RemoveIf removes the responses of the queries whose keys match, and
+// returns the number removed.
+func (s *staleStore) RemoveIf(match func(key string) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for name, e := range s.entries {
+		if !match(e.key) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		s.size -= e.size
+		delete(s.entries, name)
+		n++
+	}
+	return n
+}
+
+// evict removes the oldest responses until the store is within its size
+// limit. The caller must hold s.mu.
+func (s *staleStore) evict() {
+	if s.size <= s.maxSize {
+		return
+	}
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return s.entries[names[i]].storedAt.Before(s.entries[names[j]].storedAt)
+	})
+	for _, name := range names {
+		if s.size <= s.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		s.size -= s.entries[name].size
+		delete(s.entries, name)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStaleStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fenster-stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newStaleStore(dir, 20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("a", []byte("aaaa"))
+	s.Set("b", []byte("bbbb"))
+	if body, _, ok := s.Get("a"); !ok || string(body) != "aaaa" {
+		t.Errorf("Get(a) => %q, %v; want %q, true", body, ok, "aaaa")
+	}
+
+	// Exceeding the size limit evicts the oldest response
+	s.entries[s.fileName("a")] = staleEntry{"a", 7, time.Now().Add(-time.Minute)}
+	s.Set("c", []byte("cccc"))
+	if _, _, ok := s.Get("a"); ok {
+		t.Errorf("expected oldest response to be evicted")
+	}
+
+	// The store is indexed when reopened, and responses too old not served
+	s, err = newStaleStore(dir, 30, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _, ok := s.Get("c"); !ok || string(body) != "cccc" {
+		t.Errorf("Get(c) after reopening => %q, %v; want %q, true", body, ok, "cccc")
+	}
+	s.maxAge = 0
+	if _, _, ok := s.Get("c"); ok {
+		t.Errorf("expected response older than max age not to be served")
+	}
+
+	// Responses put are stored in the background
+	s.maxAge = time.Hour
+	s.Put("d", []byte("dddd"))
+	s.flush()
+	if body, _, ok := s.Get("d"); !ok || string(body) != "dddd" {
+		t.Errorf("Get(d) after Put => %q, %v; want %q, true", body, ok, "dddd")
+	}
+
+	// Responses are removed by the keys of their queries
+	if n := s.RemoveIf(func(key string) bool { return key == "d" }); n != 1 {
+		t.Errorf("RemoveIf(d) => %d; want 1", n)
+	}
+	if _, _, ok := s.Get("d"); ok {
+		t.Errorf("expected removed response not to be served")
+	}
+	if _, _, ok := s.Get("c"); !ok {
+		t.Errorf("expected response not matching to be kept")
+	}
+}
+
+func TestQueryServesStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fenster-stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	down := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "result")
+	}))
+	defer ts.Close()
+
+	r := newRepo(newEndpointPool([]string{ts.URL}, 5, time.Second), clientOptions{OpenTimeout: time.Second, ReadTimeout: time.Second})
+	r.stale, err = newStaleStore(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, down = range []bool{false, true} {
+		resp, err := r.Query(context.Background(), "outgoing", "SELECT * WHERE { ?s ?p ?o }", "results")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp)
+		resp.Close()
+		r.stale.flush()
+		if string(body) != "result" {
+			t.Errorf("expected %q, got %q", "result", body)
+		}
+		if stale := !cachedAt(resp).IsZero(); stale != down {
+			t.Errorf("endpoint down: %v, but served stale: %v", down, stale)
+		}
+	}
+}
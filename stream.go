@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// chunkSize is the size of the chunks streamed responses are flushed in.
+const chunkSize = 32 << 10
+
+// limitBody is a response body failing with an errTooLarge queryError when
+// read beyond n bytes.
+type limitBody struct {
+	io.ReadCloser
+	n   int64
+	err error
+}
+
+func (b *limitBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.n <= 0 {
+		// The limit is reached; the body fits if there is no more
+		var one [1]byte
+		n, err := b.ReadCloser.Read(one[:])
+		if n == 0 {
+			return 0, err
+		}
+		b.err = newQueryError(errTooLarge, 0, "")
+		return 0, b.err
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
+
+// truncatedBody is the part of a response body read until it exceeded the
+// size limit, failing with err when read to its end.
+type truncatedBody struct {
+	*bytes.Reader
+	err error
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		err = b.err
+	}
+	return n, err
+}
+
+func (b *truncatedBody) Close() error {
+	return nil
+}
+
+// errBodyClosed is the error of a response body closed before its end.
+var errBodyClosed = errors.New("response body closed before its end")
+
+// teeBody is a response body copied into a buffer as it is read. Once read to
+// its end, done is called with the body, or with the error failing to read
+// it; or with errBodyClosed if closed before, without reading the rest.
+type teeBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func([]byte, error)
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err != nil {
+		b.end(err)
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	b.end(errBodyClosed)
+	return b.ReadCloser.Close()
+}
+
+// end calls done, once.
+func (b *teeBody) end(err error) {
+	if b.done == nil {
+		return
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	b.done(b.buf.Bytes(), err)
+	b.done = nil
+}
+
+// errReader remembers the error of reading r, other than io.EOF, for decoders
+// which do not report it.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// flushWriter writes to a response, flushing it after each write.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
+
+// streamResponse writes the output of render to the response, flushed in
+// chunks of chunkSize bytes. The headers must be set before.
+func streamResponse(w http.ResponseWriter, render func(io.Writer) error) error {
+	bw := bufio.NewWriterSize(flushWriter{w}, chunkSize)
+	if err := render(bw); err != nil {
+		return fmt.Errorf("failed to stream response: %v", err)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitBody(t *testing.T) {
+	tests := []struct {
+		body  string
+		limit int64
+		want  string
+		large bool
+	}{
+		{"0123456789", 10, "0123456789", false},
+		{"0123456789", 20, "0123456789", false},
+		{"0123456789", 4, "0123", true},
+	}
+	for _, tt := range tests {
+		b := &limitBody{ReadCloser: ioutil.NopCloser(strings.NewReader(tt.body)), n: tt.limit}
+		got, err := ioutil.ReadAll(b)
+		if string(got) != tt.want || tooLarge(err) != tt.large {
+			t.Errorf("limit %d: read %q, %v; want %q, too large: %v", tt.limit, got, err, tt.want, tt.large)
+		}
+	}
+}
+
+func TestQueryTooLarge(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(tsvResults))
+	}))
+	defer ts.Close()
+
+	r := newRepo(newEndpointPool([]string{ts.URL}, 5, time.Second),
+		clientOptions{OpenTimeout: time.Second, ReadTimeout: time.Second})
+	defer r.Close()
+	r.maxResponseSize = int64(strings.Index(tsvResults, "\t\t\t_:b1"))
+	r.cache = newQueryCache(10, 60, nil)
+
+	for i := 0; i < 2; i++ {
+		resp, err := r.Query(context.Background(), "toolarge", "SELECT * {}", "results")
+		if err != nil {
+			t.Fatal(err)
+		}
+		solutions, err := parseSolutions(resp)
+		resp.Close()
+		if !tooLarge(err) || len(solutions) != 1 {
+			t.Errorf("expected the solution before the limit with an error, got %d, %v", len(solutions), err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected responses exceeding the limit not to be cached, got %d requests", requests)
+	}
+}
+
+func TestQueryStreamsCached(t *testing.T) {
+	read := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+		<-read
+		w.Write([]byte("second"))
+	}))
+	defer ts.Close()
+
+	r := newRepo(newEndpointPool([]string{ts.URL}, 5, time.Second),
+		clientOptions{OpenTimeout: time.Second, ReadTimeout: time.Second})
+	defer r.Close()
+	r.cache = newQueryCache(10, 60, nil)
+
+	resp, err := r.Query(context.Background(), "outgoing", "SELECT * {}", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The start of the body is read before the endpoint has sent the rest
+	first := make([]byte, len("first "))
+	if _, err := io.ReadFull(resp, first); err != nil || string(first) != "first " {
+		t.Fatalf("read %q, %v; want %q", first, err, "first ")
+	}
+	close(read)
+	ioutil.ReadAll(resp)
+	resp.Close()
+
+	resp, err = r.Query(context.Background(), "outgoing", "SELECT * {}", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp)
+	resp.Close()
+	if string(body) != "first second" {
+		t.Errorf("expected the whole body to be cached, got %q", body)
+	}
+}
+
+func TestQueryCachesParsed(t *testing.T) {
+	requests := 0
+	block := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(jsonResults))
+		if r.FormValue("query") == "SELECT * { ?s ?p ?o }" {
+			// The rest of a large response is never sent
+			w.(http.Flusher).Flush()
+			<-block
+		}
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	r := newRepo(newEndpointPool([]string{ts.URL}, 5, time.Second),
+		clientOptions{OpenTimeout: time.Second, ReadTimeout: time.Second})
+	defer r.Close()
+	r.protocol = "form"
+	r.cache = newQueryCache(10, 60, nil)
+
+	// Results parsed to their end are cached
+	for i := 0; i < 2; i++ {
+		resp, err := r.Query(context.Background(), "outgoing", "SELECT * {}", "results")
+		if err != nil {
+			t.Fatal(err)
+		}
+		solutions, err := parseSolutions(resp)
+		resp.Close()
+		if err != nil || len(solutions) != 2 {
+			t.Fatalf("parseSolutions => %d, %v; want 2, nil", len(solutions), err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected parsed results to be cached, got %d requests", requests)
+	}
+
+	// A response closed before its end is not read to its end, nor cached
+	resp, err := r.Query(context.Background(), "outgoing", "SELECT * { ?s ?p ?o }", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan bool)
+	go func() {
+		resp.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("expected closing the response not to wait for the rest of it")
+	}
+	if _, ok := r.cache.results.Get("results\nSELECT * { ?s ?p ?o }"); ok {
+		t.Errorf("expected the response closed early not to be cached")
+	}
+}
+
+func TestStreamResponse(t *testing.T) {
+	body := strings.Repeat("fenster ", chunkSize/4)
+	render := func(w io.Writer) error {
+		for _, word := range strings.SplitAfter(body, " ") {
+			if _, err := io.WriteString(w, word); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	if err := streamResponse(w, render); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != body || !w.Flushed {
+		t.Errorf("expected the body to be streamed in chunks")
+	}
+}
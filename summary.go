@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+const (
+	outgoing = "outgoing"
+	incoming = "incoming"
+)
+
+// restriction narrows a description down to one direction, and optionally to
+// the triples of one predicate and/or graph.
+type restriction struct {
+	Direction string
+	Predicate string
+	Graph     string
+}
+
+// requestRestriction returns the restriction given by the direction,
+// predicate and graph parameters of the request. Invalid parameters are
+// ignored.
+func requestRestriction(r *http.Request) restriction {
+	var rs restriction
+	switch dir := r.FormValue("direction"); dir {
+	case outgoing, incoming:
+		rs.Direction = dir
+	default:
+		return rs
+	}
+	if p := r.FormValue("predicate"); validIRI(p) {
+		rs.Predicate = p
+	}
+	if g := r.FormValue("graph"); validIRI(g) {
+		rs.Graph = g
+	}
+	return rs
+}
+
+// includes returns true if triples in the given direction are included by
+// the restriction.
+func (rs restriction) includes(direction string) bool {
+	return rs.Direction == "" || rs.Direction == direction
+}
+
+// url returns the URL of a drill-down restricted by rs, on the first page.
+func (rs restriction) url(r *http.Request) string {
+	u := *r.URL
+	params := u.Query()
+	for _, p := range []string{"page", "offset", "direction", "predicate", "graph"} {
+		params.Del(p)
+	}
+	if rs.Direction != "" {
+		params.Set("direction", rs.Direction)
+	}
+	if rs.Predicate != "" {
+		params.Set("predicate", rs.Predicate)
+	}
+	if rs.Graph != "" {
+		params.Set("graph", rs.Graph)
+	}
+	u.RawQuery = params.Encode()
+	return u.RequestURI()
+}
+
+// predicateCount is the number of triples with a predicate in a graph, with
+// the link to browse them.
+type predicateCount struct {
+	Graph     string
+	Predicate string
+	Count     int
+	URL       string
+}
+
+// summarize returns the number of triples in one direction of the resource,
+// grouped by graph and predicate.
+func summarize(r *http.Request, uri string, direction string) ([]predicateCount, error) {
+	tag := "summaryOutgoing"
+	if direction == incoming {
+		tag = "summaryIncoming"
+	}
+	solutions, err := querySolutions(r.Context(), tag, resourceQuery{URI: uri})
+	if err != nil {
+		return nil, err
+	}
+	var counts []predicateCount
+	for _, m := range solutions {
+		if m["g"] == nil || m["p"] == nil {
+			continue
+		}
+		rs := restriction{direction, m["p"].String(), m["g"].String()}
+		pc := predicateCount{
+			Graph:     m["g"].String(),
+			Predicate: m["p"].String(),
+			Count:     intValue(m["n"]),
+			URL:       rs.url(r),
+		}
+		if conf.Vocab.Enabled {
+			pc.Graph = prefixify(&conf.Vocab.Dict, pc.Graph)
+			pc.Predicate = prefixify(&conf.Vocab.Dict, pc.Predicate)
+		}
+		counts = append(counts, pc)
+	}
+	return counts, nil
+}
+
+// summarizeHub returns the summaries of the directions of a description
+// which has more triples than fits on a page. The summaries are fetched
+// concurrently; a failing summary is left out.
+func summarizeHub(r *http.Request, d *description) (out, in []predicateCount) {
+	var wg sync.WaitGroup
+	if d.MoreOutgoing {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, _ = summarize(r, d.URI, outgoing)
+		}()
+	}
+	if d.MoreIncoming {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in, _ = summarize(r, d.URI, incoming)
+		}()
+	}
+	wg.Wait()
+	return out, in
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// clientOptions are the settings of the HTTP client querying the SPARQL
+// endpoints.
+type clientOptions struct {
+	// OpenTimeout bounds dialing and the TLS handshake; ReadTimeout the wait
+	// for the response headers.
+	OpenTimeout time.Duration
+	ReadTimeout time.Duration
+	// MaxIdleConns is the number of idle connections kept open to each
+	// endpoint, for at most IdleTimeout.
+	MaxIdleConns int
+	IdleTimeout  time.Duration
+	// DisableCompression turns off gzip-compressed responses.
+	DisableCompression bool
+	// DisableHTTP2 turns off HTTP/2 to endpoints served over TLS.
+	DisableHTTP2 bool
+	// TLS is the TLS configuration of the connections, if not the default;
+	// see newTLSConfig.
+	TLS *tls.Config
+	// Credentials authenticate the requests, if given.
+	Credentials *credentials
+}
+
+// newTransport returns the transport of the HTTP client querying the SPARQL
+// endpoints. Responses are requested gzip-compressed, and decompressed
+// transparently, unless compression is disabled.
+func newTransport(o clientOptions) *http.Transport {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   o.OpenTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   o.OpenTimeout,
+		ResponseHeaderTimeout: o.ReadTimeout,
+		MaxIdleConns:          o.MaxIdleConns * 4,
+		MaxIdleConnsPerHost:   o.MaxIdleConns,
+		IdleConnTimeout:       o.IdleTimeout,
+		DisableCompression:    o.DisableCompression,
+		ForceAttemptHTTP2:     !o.DisableHTTP2,
+		TLSClientConfig:       o.TLS,
+	}
+	if o.DisableHTTP2 {
+		// A non-nil, empty map turns off HTTP/2
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t
+}
+
+// traceQuery returns a context tracing the request of a query with the given
+// tag, recording in the registry if its connection was reused, and the
+// latency until the response headers. The returned function must be called
+// when the response headers are received.
+func traceQuery(ctx context.Context, registry metrics.Registry, tag string) (context.Context, func()) {
+	prefix := "upstream." + tag + "."
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				metrics.GetOrRegisterCounter(prefix+"connReused", registry).Inc(1)
+			} else {
+				metrics.GetOrRegisterCounter(prefix+"connNew", registry).Inc(1)
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				metrics.GetOrRegisterTimer(prefix+"connect", registry).UpdateSince(start)
+			}
+		},
+	}
+	done := func() {
+		metrics.GetOrRegisterTimer(prefix+"latency", registry).UpdateSince(start)
+	}
+	return httptrace.WithClientTrace(ctx, trace), done
+}
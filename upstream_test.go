@@ -0,0 +1,53 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestUpstreamClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("expected gzip-compressed response to be requested")
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte("result"))
+		gz.Close()
+	}))
+	defer ts.Close()
+
+	r := newRepo(newEndpointPool([]string{ts.URL}, 5, time.Second),
+		clientOptions{OpenTimeout: time.Second, ReadTimeout: time.Second, MaxIdleConns: 2})
+	defer r.Close()
+	r.registry = metrics.NewRegistry()
+	for i := 0; i < 2; i++ {
+		resp, err := r.Query(context.Background(), "tracetest", "ASK {}", "results")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp)
+		resp.Close()
+		if string(b) != "result" {
+			t.Errorf("expected decompressed response, got %q", b)
+		}
+	}
+
+	get := func(name string) int64 {
+		return metrics.GetOrRegisterCounter("upstream.tracetest."+name, r.registry).Count()
+	}
+	if get("connNew") != 1 || get("connReused") != 1 {
+		t.Errorf("expected the connection to be reused, got %d new and %d reused", get("connNew"), get("connReused"))
+	}
+	if n := metrics.GetOrRegisterTimer("upstream.tracetest.latency", r.registry).Count(); n != 2 {
+		t.Errorf("expected latency of 2 queries, got %d", n)
+	}
+}
@@ -1,74 +1,209 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"net/http"
-	"net/url"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/knakk/rdf"
-	"github.com/mreiferson/go-httpclient"
+	"github.com/rcrowley/go-metrics"
 )
 
 type remoteRepo struct {
-	endpoint string
-	client   *http.Client
+	endpoints *endpointPool
+	transport *http.Transport
+	client    *http.Client
+	// cache of query results, if enabled
+	cache *queryCache
+	// stale store of query results, if enabled
+	stale *staleStore
+	// limiter of the queries in flight, if enabled
+	limiter *limiter
+	// registry records the metrics of the requests to the endpoints
+	registry metrics.Registry
+	// timeout is the deadline of each request to an endpoint
+	timeout time.Duration
+	// maxResponseSize is the number of bytes read of a response before it
+	// fails with an errTooLarge queryError, or 0 for no limit
+	maxResponseSize int64
+	// protocol is how queries are sent; see newQueryRequest
+	protocol string
+	// dialect is the profile of the SPARQL store
+	dialect dialect
+	// timeoutHint is true if the timeout is sent to the store, as the
+	// timeout parameter of the dialect
+	timeoutHint bool
+	// resultsAccept is the Accept header of queries with SPARQL results;
+	// see resultsAccept
+	resultsAccept string
+	// defaultGraphs and namedGraphs are the RDF dataset of the queries, if
+	// given
+	defaultGraphs []string
+	namedGraphs   []string
 }
 
-func newRepo(endpoint string, openTimeout, readTimeout time.Duration) *remoteRepo {
-	transport := &httpclient.Transport{
-		ConnectTimeout:        openTimeout,
-		RequestTimeout:        openTimeout + readTimeout,
-		ResponseHeaderTimeout: readTimeout,
-	}
+func newRepo(endpoints *endpointPool, o clientOptions) *remoteRepo {
+	transport := newTransport(o)
 	client := &http.Client{Transport: transport}
-	return &remoteRepo{endpoint: endpoint, client: client}
+	if o.Credentials != nil {
+		client.Transport = newAuthTransport(transport, o.Credentials)
+	}
+	return &remoteRepo{
+		endpoints: endpoints,
+		transport: transport,
+		client:    client,
+		registry:  metrics.DefaultRegistry,
+		timeout:   o.OpenTimeout + o.ReadTimeout,
+	}
 }
 
 func (r *remoteRepo) Close() {
-	//r.client.Transport.Close()
+	r.transport.CloseIdleConnections()
+}
+
+// Query sends a request to the remote SPARQL endpoints and returns the
+// unparsed response body. If the results of queries with the given tag are
+// cached, the response body is served from the cache when present. If the
+// query fails, and the stale store has its last successful response, that is
+// served instead; see cachedAt. Partial results, which a store may answer
+// with when given the timeout hint, are served but neither cached nor stored.
+// The query is canceled when the context is, typically when the client of the
+// request needing it disconnects. A response exceeding the size limit is read
+// up to the limit, and then fails with an errTooLarge queryError.
+//
+// The response body is streamed from the endpoint as it is read. When it is
+// to be cached or stored, it is also copied into memory as it is read; only
+// the size limit bounds that copy. A response failing while it is read is
+// not served from the stale store.
+func (r *remoteRepo) Query(ctx context.Context, tag string, query string, format string) (io.ReadCloser, error) {
+	cached := r.cache != nil && r.cache.tagTTL(tag) > 0
+	if !cached && r.stale == nil {
+		return r.query(ctx, tag, query, format)
+	}
+
+	key := format + "\n" + query
+	fetch := func(done func([]byte, error)) (io.ReadCloser, error) {
+		return r.fetch(ctx, tag, key, query, format, done)
+	}
+	var body io.ReadCloser
+	var err error
+	if cached {
+		body, err = r.cache.Do(ctx, key, tag, fetch)
+	} else {
+		body, err = fetch(func([]byte, error) {})
+	}
+	if err != nil {
+		if r.stale != nil && ctx.Err() == nil {
+			if b, storedAt, ok := r.stale.Get(key); ok {
+				return &staleBody{ioutil.NopCloser(bytes.NewReader(b)), storedAt}, nil
+			}
+		}
+		return nil, err
+	}
+	return body, nil
 }
 
-// Query sends a request to a remote SPARQL endpoint and returns the unparsed
-// response body
-func (r *remoteRepo) Query(endpoint string, query string, format string) (io.ReadCloser, error) {
-	reqDefaults := url.Values{}
-	reqDefaults.Set("query", query)
+// errPartialResults is the error given to the done function of fetch with
+// partial results, so they are not cached.
+var errPartialResults = errors.New("partial results")
+
+// fetch sends a request to the remote SPARQL endpoints and returns the
+// response body, copied as it is read. Once read whole, it is kept in the
+// stale store if enabled, and given to done; or the error failing to read it.
+// Partial results are not copied, and errPartialResults given to done at once.
+func (r *remoteRepo) fetch(ctx context.Context, tag string, key string, query string, format string, done func([]byte, error)) (io.ReadCloser, error) {
+	ctx, partial := withPartialFlag(ctx)
+	resp, err := r.query(ctx, tag, query, format)
+	if err != nil {
+		return nil, err
+	}
+	if partial() {
+		done(nil, errPartialResults)
+		return resp, nil
+	}
+	return &teeBody{ReadCloser: resp, done: func(body []byte, err error) {
+		if err == nil && r.stale != nil {
+			r.stale.Put(key, body)
+		}
+		done(body, err)
+	}}, nil
+}
 
-	switch format {
-	case "json":
-		reqDefaults.Set("format", "application/sparql-results+json")
-	case "rdf":
-		reqDefaults.Set("format", "application/x-trig")
-	default:
-		reqDefaults.Set("format", "application/sparql-results+json")
+// query sends a request to the remote SPARQL endpoints, failing over to the
+// next endpoint if one fails, and returns the unparsed response body. If the
+// limiter is enabled, the query waits for a slot, held until the body is
+// closed.
+func (r *remoteRepo) query(ctx context.Context, tag string, query string, format string) (io.ReadCloser, error) {
+	if r.limiter != nil {
+		if err := r.limiter.Acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+	body, err := r.endpoints.Do(ctx, func(ctx context.Context, endpoint string) (io.ReadCloser, error) {
+		return r.queryEndpoint(ctx, endpoint, tag, query, format)
+	})
+	if r.limiter == nil {
+		return body, err
+	}
+	if err != nil {
+		r.limiter.Release()
+		return nil, err
 	}
+	return &releaseBody{ReadCloser: body, release: r.limiter.Release}, nil
+}
 
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s?%v", endpoint, reqDefaults.Encode()), nil)
+// queryEndpoint sends a request to a remote SPARQL endpoint and returns the
+// unparsed response body. The request is canceled if not completed within the
+// timeout of the repository, or when the body is closed. Its connection and
+// latency are recorded in the metrics of the query tag. Failures are returned
+// as a queryError, but for cancellation.
+func (r *remoteRepo) queryEndpoint(ctx context.Context, endpoint string, tag string, query string, format string) (io.ReadCloser, error) {
+	req, err := newQueryRequest(endpoint, r.protocol, query, r.accept(format), r.queryParams())
 	if err != nil {
 		return nil, fmt.Errorf("error preparing http request: %v", err)
 	}
 
-	resp, err := r.client.Do(req)
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	ctx, traced := traceQuery(ctx, r.registry, tag)
+	resp, err := r.client.Do(req.WithContext(ctx))
 	if err != nil {
-		// trim URL from error message
-		i := strings.Index(err.Error(), "dial")
-		if i != -1 {
-			return nil, errors.New(err.Error()[i:])
-		}
+		err = transportError(ctx, err)
+		cancel()
 		return nil, err
 	}
 
+	traced()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("SPARQL endpoint responded with HTTP status code: %v", resp.StatusCode)
+		msg := endpointMessage(resp)
+		resp.Body.Close()
+		cancel()
+		return nil, newQueryError(errQuery, resp.StatusCode, msg)
 	}
+	r.dialect.flagPartial(ctx, resp)
 
-	return resp.Body, nil
+	var body io.ReadCloser = upstreamBody{resp.Body, ctx}
+	if r.maxResponseSize > 0 {
+		body = &limitBody{ReadCloser: body, n: r.maxResponseSize}
+	}
+	return cancelBody{body, cancel}, nil
+}
+
+// iriRg matches absolute IRIs which can be safely interpolated as an IRIREF
+// in SPARQL queries.
+var iriRg = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.\-]*:[^\x00-\x20<>"{}|^\x60\\]*$`)
+
+// validIRI returns true if the string is an absolute IRI without any
+// characters which are illegal in a SPARQL IRIREF.
+func validIRI(s string) bool {
+	return iriRg.MatchString(s)
 }
 
 // prefixify returns the prefixed form of an URI if the prefix and namespaces
@@ -96,35 +231,33 @@ func prefixify(prefixes *[][]string, uri string) string {
 	return uriOriginal
 }
 
-func rejectWhereEmpty(key string, solutions []map[string]rdf.Term) []map[string]interface{} {
+// presentation holds the request specific information used when presenting
+// terms as HTML.
+type presentation struct {
+	// Labels of IRIs, keyed by IRI.
+	Labels map[string]string
+	// Languages are the preferred languages, most preferred first.
+	Languages []string
+	// BlankNodes are the solutions describing the blank nodes of the
+	// resource, keyed by blank node label.
+	BlankNodes map[string][]map[string]rdf.Term
+}
+
+func rejectWhereEmpty(key string, solutions []map[string]rdf.Term, pres *presentation) []map[string]interface{} {
 	// TODO clean up this function; choose another name too..
+	var collapsed map[int]bool
+	if conf.UI.CollapseLanguages {
+		collapsed = collapsedLanguages(pres.Languages, solutions)
+	}
 	included := make([]map[string]interface{}, 1)
-	for _, m := range solutions {
+	for i, m := range solutions {
 		if m[key] != nil {
 			tm := make(map[string]interface{})
+			if collapsed[i] {
+				tm["class"] = "other-lang"
+			}
 			for k, v := range m {
-				term := v.Serialize(rdf.Turtle)
-				if k != "g" && k != "p" && strings.HasPrefix(term, "<"+conf.BaseURI) {
-
-					// URL without enclosing angle brackets
-					link := strings.Trim(term, "<>")
-
-					if conf.UI.FetchLiterals {
-						link = fmt.Sprintf("<div class='relative'><a class=\"resource-link\" href='%v'>%v</a><div class=\"tooltip\"><strong>%s</strong><div class='literals'>...</div></div></div>",
-							link, template.HTMLEscapeString(term), template.HTMLEscapeString(term))
-					} else {
-						link = fmt.Sprintf("<a href='%v'>%v</a>",
-							link, template.HTMLEscapeString(term))
-					}
-
-					tm[k] = template.HTML(link)
-				} else {
-					if conf.Vocab.Enabled {
-						tm[k] = prefixify(&conf.Vocab.Dict, term)
-					} else {
-						tm[k] = term
-					}
-				}
+				tm[k] = termHTML(k, v, pres, 0)
 			}
 			included = append(included, tm)
 		}
@@ -132,22 +265,147 @@ func rejectWhereEmpty(key string, solutions []map[string]rdf.Term) []map[string]
 	return included
 }
 
-// findTitle iterates over solutions and returns the first literal string where the RDF
-// predicate matches any of the predicates in titlePredicates, or an empty string
-// if none is found.
-func findTitle(titlePredicates []string, solutions []map[string]rdf.Term) string {
+// termHTML returns the presentation of the term bound to the variable k of a
+// solution; either a string, or HTML linking to the resource presenting it.
+func termHTML(k string, v rdf.Term, pres *presentation, depth int) interface{} {
+	if k != "g" && k != "p" && v.Type() == rdf.TermBlank {
+		return template.HTML(blankNodeHTML(v, pres, depth))
+	}
+	term := v.Serialize(rdf.Turtle)
+	label, hasLabel := pres.Labels[v.String()]
+	if k == "g" || k == "p" || v.Type() != rdf.TermIRI {
+		hasLabel = false
+	}
+	if k != "g" && k != "p" && strings.HasPrefix(term, "<"+conf.BaseURI) {
+
+		// URL without enclosing angle brackets
+		link := template.HTMLEscapeString(strings.Trim(term, "<>"))
+
+		text := template.HTMLEscapeString(term)
+		if hasLabel {
+			text = labelHTML(label, term)
+		}
+
+		if conf.UI.FetchLiterals {
+			link = fmt.Sprintf("<div class='relative'><a class=\"resource-link\" href='%v'>%v</a><div class=\"tooltip\"><strong>%s</strong><div class='literals'>...</div></div></div>",
+				link, text, template.HTMLEscapeString(term))
+		} else {
+			link = fmt.Sprintf("<a href='%v'>%v</a>",
+				link, text)
+		}
+
+		return template.HTML(link)
+	} else if hasLabel {
+		return template.HTML(labelHTML(label, term))
+	}
+	if conf.Vocab.Enabled {
+		return prefixify(&conf.Vocab.Dict, term)
+	}
+	return term
+}
+
+// propertyValue is an object of a predicate, with the graphs where it is
+// stated.
+type propertyValue struct {
+	Object interface{}
+	Graphs []interface{}
+	Class  string
+}
+
+// propertyGroup is a predicate with all its objects.
+type propertyGroup struct {
+	Predicate interface{}
+	Values    []propertyValue
+}
+
+// groupByPredicate groups the rows of the subject table produced by
+// rejectWhereEmpty by predicate, in the order the predicates first appear.
+// Objects stated in several graphs are listed once, with all the graphs.
+func groupByPredicate(rows []map[string]interface{}) []propertyGroup {
+	var groups []propertyGroup
+	groupIdx := make(map[string]int)
+	valueIdx := make(map[string]int)
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		p := fmt.Sprint(row["p"])
+		i, ok := groupIdx[p]
+		if !ok {
+			i = len(groups)
+			groupIdx[p] = i
+			groups = append(groups, propertyGroup{Predicate: row["p"]})
+		}
+		class, _ := row["class"].(string)
+		o := p + " " + fmt.Sprint(row["o"])
+		if j, ok := valueIdx[o]; ok {
+			v := &groups[i].Values[j]
+			v.Graphs = append(v.Graphs, row["g"])
+			// A value is collapsed only if collapsed in all its graphs
+			if class == "" {
+				v.Class = ""
+			}
+			continue
+		}
+		valueIdx[o] = len(groups[i].Values)
+		groups[i].Values = append(groups[i].Values, propertyValue{
+			Object: row["o"],
+			Graphs: []interface{}{row["g"]},
+			Class:  class,
+		})
+	}
+	return groups
+}
+
+// viewURL returns the URL of the request in the given view of the resource
+// page. The default view is the grouped one.
+func viewURL(r *http.Request, view string) string {
+	u := *r.URL
+	params := u.Query()
+	params.Del("view")
+	if view != "" {
+		params.Set("view", view)
+	}
+	u.RawQuery = params.Encode()
+	return u.RequestURI()
+}
+
+// labelHTML returns the HTML presenting a label of an IRI, with the IRI as
+// secondary text.
+func labelHTML(label, term string) string {
+	if conf.Vocab.Enabled {
+		term = prefixify(&conf.Vocab.Dict, term)
+	}
+	return fmt.Sprintf("<span class=\"label\">%s</span> <span class=\"iri\">%s</span>",
+		template.HTMLEscapeString(label), template.HTMLEscapeString(term))
+}
+
+// findTitle iterates over solutions and returns the value of the literal in the
+// most preferred language where the RDF predicate matches any of the
+// predicates in titlePredicates, or an empty string if none is found. The
+// order of titlePredicates is used to break ties.
+func findTitle(titlePredicates []string, langs []string, solutions []map[string]rdf.Term) string {
 	if len(titlePredicates) == 0 {
 		return ""
 	}
 
+	var title string
+	bestLang, bestPred := -1, -1
 	for _, m := range solutions {
-		for _, p := range titlePredicates {
-			if m["p"].Serialize(rdf.Turtle) == "<"+p+">" {
-				return m["o"].Serialize(rdf.Turtle)
+		if m["o"] == nil || m["o"].Type() != rdf.TermLiteral {
+			continue
+		}
+		for i, p := range titlePredicates {
+			if m["p"].Serialize(rdf.Turtle) != "<"+p+">" {
+				continue
+			}
+			lr := languageRank(langs, literalLanguage(m["o"]))
+			if bestLang == -1 || lr < bestLang || (lr == bestLang && i < bestPred) {
+				title, bestLang, bestPred = m["o"].String(), lr, i
 			}
 		}
 	}
-	return ""
+	return title
 }
 
 // findImages iterates over solutions and returns any images, that is,
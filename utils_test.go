@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestPrefixify(t *testing.T) {
 	prefixes := [][]string{
@@ -28,3 +31,48 @@ func TestPrefixify(t *testing.T) {
 		}
 	}
 }
+
+func TestValidIRI(t *testing.T) {
+	var iriTests = []struct {
+		in  string
+		out bool
+	}{
+		{"http://data.deichman.no/resource/tnr_1140686", true},
+		{"urn:isbn:9788205353380", true},
+		{"", false},
+		{"/resource/tnr_1", false},
+		{"http://example.org/> } ; DROP ALL #", false},
+		{"http://example.org/a b", false},
+	}
+
+	for i, tt := range iriTests {
+		if validIRI(tt.in) != tt.out {
+			t.Errorf("%d) validIRI(%q) expected %v", i, tt.in, tt.out)
+		}
+	}
+}
+
+func TestGroupByPredicate(t *testing.T) {
+	rows := []map[string]interface{}{
+		nil,
+		{"g": "g1", "p": "dc:title", "o": "\"Sult\"@nb"},
+		{"g": "g1", "p": "dc:subject", "o": "<a>"},
+		{"g": "g1", "p": "dc:title", "o": "\"Hunger\"@en", "class": "other-lang"},
+		{"g": "g2", "p": "dc:subject", "o": "<a>"},
+		{"g": "g2", "p": "dc:subject", "o": "<b>"},
+	}
+	want := []propertyGroup{
+		{"dc:title", []propertyValue{
+			{"\"Sult\"@nb", []interface{}{"g1"}, ""},
+			{"\"Hunger\"@en", []interface{}{"g1"}, "other-lang"},
+		}},
+		{"dc:subject", []propertyValue{
+			{"<a>", []interface{}{"g1", "g2"}, ""},
+			{"<b>", []interface{}{"g2"}, ""},
+		}},
+	}
+
+	if got := groupByPredicate(rows); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupByPredicate => %v; want %v", got, want)
+	}
+}